@@ -0,0 +1,135 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type memberBalance struct {
+	memberID     string
+	expected     decimal.Decimal
+	paid         decimal.Decimal
+	arrearsSince time.Time
+}
+
+// Negative when the member is in arrears, positive when they're in credit.
+func (b *memberBalance) balance() decimal.Decimal {
+	return b.paid.Sub(b.expected)
+}
+
+/*
+Builds a running balance for each member from the membership payments in the
+ledger. From the month of their first payment up to the period being run
+(or, once they've lapsed, the last month they paid for) each month adds the
+member's fee to what's expected, and each payment is credited to the members
+it was matched to, split evenly. When the member pays one of the correct
+amounts, that's their fee from then on. Refunds are taken off in the same way.
+Months covered by an annual payment don't accrue a fee, and the annual
+payment isn't credited. arrearsSince is the first month of the member's
+current run of arrears.
+*/
+func identifyBalances(entries []*ledgerEntry, statuses []*memberStatus, references *referenceMatcher, schedules []*feeSchedule, month time.Time) (balances []*memberBalance, err error) {
+	from, until := monthPeriod(month)
+	payments := make(map[string][]*ledgerEntry)
+	for _, entry := range entries {
+		if !(entry.isMembershipPayment() || entry.classification == txnRefund) || !entry.txn.date.Before(until) {
+			continue
+		}
+
+		for _, memberID := range entry.memberIDs {
+			payments[memberID] = append(payments[memberID], entry)
+		}
+	}
+
+	rules := references.memberRules()
+	for _, status := range statuses {
+		memberPayments := payments[status.memberID]
+		if len(memberPayments) == 0 {
+			continue
+		}
+
+		paidIn := make(map[time.Time]decimal.Decimal)
+		paying := make(map[time.Time]decimal.Decimal)
+		annuallyCovered := make(map[time.Time]bool)
+		first := from
+		for _, entry := range memberPayments {
+			paymentMonth, _ := monthPeriod(entry.txn.date)
+			if paymentMonth.Before(first) {
+				first = paymentMonth
+			}
+
+			months := 1
+			schedule, err := selectFeeSchedule(schedules, entry.txn.date)
+			if err == nil && entry.isMembershipPayment() {
+				months = schedule.coverageMonths(entry.txn.amount)
+			}
+
+			if months > 1 {
+				for i := 0; i < months; i++ {
+					annuallyCovered[paymentMonth.AddDate(0, i, 0)] = true
+				}
+
+				continue
+			}
+
+			paidIn[paymentMonth] = paidIn[paymentMonth].Add(entry.memberShare())
+			if entry.isMembershipPayment() {
+				paying[paymentMonth] = entry.txn.amount
+			}
+		}
+
+		last := from
+		if !status.isPaying() {
+			last, _ = monthPeriod(status.coveredUntil.AddDate(0, -1, 0))
+		}
+
+		balance := &memberBalance{memberID: status.memberID}
+		lastPaying := decimal.Zero
+		for current := first; !current.After(last); current = current.AddDate(0, 1, 0) {
+			if amount, ok := paying[current]; ok {
+				lastPaying = amount
+			}
+
+			if !annuallyCovered[current] {
+				schedule, err := selectFeeSchedule(schedules, current)
+				if err == nil {
+					fee, err := schedule.memberFee(rules[status.memberID], lastPaying)
+					if err != nil {
+						return nil, err
+					}
+
+					balance.expected = balance.expected.Add(fee)
+				}
+			}
+
+			balance.paid = balance.paid.Add(paidIn[current])
+			if !balance.balance().IsNegative() {
+				balance.arrearsSince = time.Time{}
+			} else if balance.arrearsSince.IsZero() {
+				balance.arrearsSince = current
+			}
+		}
+
+		balances = append(balances, balance)
+	}
+
+	sort.Slice(balances, func(i, j int) bool {
+		return balances[i].memberID < balances[j].memberID
+	})
+
+	return balances, nil
+}
+
+func filterBalances(balances []*memberBalance) (arrears []*memberBalance, credit []*memberBalance) {
+	for _, balance := range balances {
+		if balance.balance().IsNegative() {
+			arrears = append(arrears, balance)
+		} else if balance.balance().IsPositive() {
+			credit = append(credit, balance)
+		}
+	}
+
+	return arrears, credit
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestIdentifyBalances(t *testing.T) {
+	schedules := []*feeSchedule{
+		{
+			effectiveFrom:  time.Date(2017, 4, 1, 0, 0, 0, 0, time.UTC),
+			correctAmounts: []decimal.Decimal{decimal.New(185, -1), decimal.New(25, 0)},
+			annualAmounts:  []decimal.Decimal{decimal.New(200, 0)},
+			categories:     map[string]decimal.Decimal{"family": decimal.New(30, 0)},
+		},
+	}
+
+	refs := newReferenceMatcher()
+	refs.add(matchLiteral, "OVERPAID", []string{"A1"})
+	refs.add(matchLiteral, "MISSED", []string{"A2"})
+	refs.add(matchLiteral, "ANNUAL", []string{"A3"})
+	family, _ := refs.add(matchLiteral, "FAMILY", []string{"A4", "A5"})
+	family.feeCategory = "family"
+	refs.add(matchLiteral, "HIGHER", []string{"A6"})
+	refs.add(matchLiteral, "HIGHER MISSED", []string{"A7"})
+
+	entries := []*ledgerEntry{
+		newTestLedgerEntry(2018, time.January, 1, "OVERPAID", decimal.New(30, 0), "A1"),
+		newTestLedgerEntry(2018, time.February, 1, "OVERPAID", decimal.New(185, -1), "A1"),
+		newTestLedgerEntry(2017, time.December, 1, "MISSED", decimal.New(185, -1), "A2"),
+		newTestLedgerEntry(2018, time.February, 1, "MISSED", decimal.New(185, -1), "A2"),
+		newTestLedgerEntry(2018, time.January, 10, "ANNUAL", decimal.New(200, 0), "A3"),
+		newTestLedgerEntry(2018, time.February, 1, "FAMILY", decimal.New(30, 0), "A4", "A5"),
+		newTestLedgerEntry(2018, time.January, 1, "HIGHER", decimal.New(25, 0), "A6"),
+		newTestLedgerEntry(2018, time.February, 1, "HIGHER", decimal.New(25, 0), "A6"),
+		newTestLedgerEntry(2017, time.December, 1, "HIGHER MISSED", decimal.New(25, 0), "A7"),
+		newTestLedgerEntry(2018, time.February, 1, "HIGHER MISSED", decimal.New(25, 0), "A7"),
+	}
+
+	month := time.Date(2018, 2, 1, 0, 0, 0, 0, time.UTC)
+	from, until := monthPeriod(month)
+	statuses := identifyMemberStatuses(identifyCoverage(entries, schedules), from, until, 30*24*time.Hour)
+	balances, err := identifyBalances(entries, statuses, refs, schedules, month)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	testCases := []struct {
+		memberID     string
+		balance      decimal.Decimal
+		arrearsSince time.Time
+	}{
+		{"A1", decimal.New(115, -1), time.Time{}},
+		{"A2", decimal.New(-185, -1), time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"A3", decimal.Zero, time.Time{}},
+		{"A4", decimal.Zero, time.Time{}},
+		{"A5", decimal.Zero, time.Time{}},
+		{"A6", decimal.Zero, time.Time{}},
+		{"A7", decimal.New(-25, 0), time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	if len(balances) != len(testCases) {
+		t.Fatalf("%v != %v", len(balances), len(testCases))
+	}
+
+	for i, testCase := range testCases {
+		if balances[i].memberID != testCase.memberID {
+			t.Fatalf("%v != %v", balances[i].memberID, testCase.memberID)
+		}
+
+		if !balances[i].balance().Equal(testCase.balance) {
+			t.Fatalf("%v: %v != %v", testCase.memberID, balances[i].balance(), testCase.balance)
+		}
+
+		if !balances[i].arrearsSince.Equal(testCase.arrearsSince) {
+			t.Fatalf("%v: %v != %v", testCase.memberID, balances[i].arrearsSince, testCase.arrearsSince)
+		}
+	}
+
+	arrears, credit := filterBalances(balances)
+	if len(arrears) != 2 || arrears[0].memberID != "A2" || len(credit) != 1 || credit[0].memberID != "A1" {
+		t.Fatalf("Wrong arrears %v or credit %v", arrears, credit)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+/*
+A bankTxnImporter understands one bank's statement format. New formats only
+need to implement this and register themselves in an init function.
+*/
+type bankTxnImporter interface {
+	// detect reports whether the first non-blank line of a statement looks
+	// like this importer's format.
+	detect(header string) bool
+	importTxns(r io.Reader) ([]*bankTxn, error)
+}
+
+const autoBankFormat = "auto"
+
+var bankTxnImporters = map[string]bankTxnImporter{}
+
+func registerBankTxnImporter(name string, importer bankTxnImporter) {
+	if _, ok := bankTxnImporters[name]; ok {
+		panic(fmt.Sprintf("Bank format %v registered twice", name))
+	}
+
+	bankTxnImporters[name] = importer
+}
+
+func bankFormatNames() (names []string) {
+	for name := range bankTxnImporters {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func statementHeader(content []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		if len(line) > 0 {
+			return line
+		}
+	}
+
+	return ""
+}
+
+func selectBankTxnImporter(format string, content []byte) (bankTxnImporter, error) {
+	if format != autoBankFormat {
+		importer, ok := bankTxnImporters[format]
+		if !ok {
+			return nil, fmt.Errorf("Unknown bank format %v (expected one of %v)", format, bankFormatNames())
+		}
+
+		return importer, nil
+	}
+
+	header := statementHeader(content)
+	var detected []string
+	for _, name := range bankFormatNames() {
+		if bankTxnImporters[name].detect(header) {
+			detected = append(detected, name)
+		}
+	}
+
+	if len(detected) != 1 {
+		return nil, fmt.Errorf("Unable to detect bank format from header %q (matched %v)", header, detected)
+	}
+
+	return bankTxnImporters[detected[0]], nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/gocarina/gocsv"
+	"github.com/shopspring/decimal"
+)
+
+func init() {
+	registerBankTxnImporter("csv", &csvBankTxnImporter{})
+}
+
+const bankTxnDateFormat = "02-Jan-06"
+
+type CsvBankTxn struct {
+	Date        string `csv:"Date"`
+	TxnType     string `csv:"Type"`
+	Description string `csv:"Description"`
+	PaidOut     string `csv:"Paid Out"`
+	Amount      string `csv:"Paid In"`
+	Balance     string `csv:"Balance"`
+}
+
+// The original bank's export: Date,Type,Description,Paid Out,Paid In,Balance.
+// Only CR rows are credits.
+type csvBankTxnImporter struct{}
+
+func (i *csvBankTxnImporter) detect(header string) bool {
+	fields, err := csv.NewReader(strings.NewReader(header)).Read()
+	if err != nil {
+		return false
+	}
+
+	expected := map[string]bool{"DATE": true, "TYPE": true, "DESCRIPTION": true, "PAID OUT": true, "PAID IN": true, "BALANCE": true}
+	for _, field := range fields {
+		delete(expected, strings.ToUpper(strings.TrimSpace(field)))
+	}
+
+	return len(expected) == 0
+}
+
+func (i *csvBankTxnImporter) importTxns(r io.Reader) (txns []*bankTxn, err error) {
+	loadedTxns := []*CsvBankTxn{}
+	err = gocsv.Unmarshal(r, &loadedTxns)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, loadedTxn := range loadedTxns {
+		date, err := time.Parse(bankTxnDateFormat, loadedTxn.Date)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse transaction date (%v): %v", *loadedTxn, err)
+		}
+
+		// Rows such as BALANCE BROUGHT FORWARD carry neither amount.
+		amount := strings.TrimSpace(loadedTxn.Amount)
+		if len(amount) == 0 && len(strings.TrimSpace(loadedTxn.PaidOut)) == 0 {
+			continue
+		} else if len(amount) == 0 {
+			amount = "-" + strings.TrimSpace(loadedTxn.PaidOut)
+		}
+
+		txn, err := newBankTxn(date, loadedTxn.Description, amount)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse transaction (%v): %v", *loadedTxn, err)
+		}
+
+		txn.txnType = strings.TrimSpace(loadedTxn.TxnType)
+		txn.credit = txn.txnType == "CR"
+		txn.original = []txnColumn{
+			{"Date", loadedTxn.Date},
+			{"Type", loadedTxn.TxnType},
+			{"Description", loadedTxn.Description},
+			{"Paid Out", loadedTxn.PaidOut},
+			{"Paid In", loadedTxn.Amount},
+			{"Balance", loadedTxn.Balance},
+		}
+
+		if balance := strings.TrimSpace(loadedTxn.Balance); len(balance) > 0 {
+			amount, err := decimal.NewFromString(balance)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to parse transaction balance (%v): %v", *loadedTxn, err)
+			}
+
+			txn.balance = &amount
+		}
+		txns = append(txns, txn)
+	}
+
+	return txns, nil
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+/*
+A mappedCsvBankTxnImporter reads any CSV statement, given the names of the
+columns holding each field. Credits come from either a signed amount column
+or separate credit and debit columns, and can be limited to rows with
+particular values in the type column.
+*/
+type mappedCsvBankTxnImporter struct {
+	dateColumn        string
+	descriptionColumn string
+	creditColumn      string
+	debitColumn       string
+	amountColumn      string
+	typeColumn        string
+	balanceColumn     string
+	creditTypes       map[string]bool
+	dateFormat        string
+	decimalSeparator  string
+	skipRows          int
+}
+
+func newMappedCsvBankTxnImporter(mapping *YamlBankCsvMapping) (*mappedCsvBankTxnImporter, error) {
+	if len(mapping.DateColumn) == 0 || len(mapping.DescriptionColumn) == 0 || len(mapping.DateFormat) == 0 {
+		return nil, fmt.Errorf("dateColumn, descriptionColumn and dateFormat are required")
+	}
+
+	if len(mapping.AmountColumn) == 0 && len(mapping.CreditColumn) == 0 {
+		return nil, fmt.Errorf("One of amountColumn or creditColumn is required")
+	}
+
+	if len(mapping.AmountColumn) > 0 && (len(mapping.CreditColumn) > 0 || len(mapping.DebitColumn) > 0) {
+		return nil, fmt.Errorf("amountColumn can't be used with creditColumn or debitColumn")
+	}
+
+	if len(mapping.CreditTypes) > 0 && len(mapping.TypeColumn) == 0 {
+		return nil, fmt.Errorf("creditTypes needs a typeColumn")
+	}
+
+	decimalSeparator := mapping.DecimalSeparator
+	if len(decimalSeparator) == 0 {
+		decimalSeparator = "."
+	} else if decimalSeparator != "." && decimalSeparator != "," {
+		return nil, fmt.Errorf("decimalSeparator must be . or , (not %v)", decimalSeparator)
+	}
+
+	creditTypes := map[string]bool{}
+	for _, creditType := range mapping.CreditTypes {
+		creditTypes[strings.ToUpper(strings.TrimSpace(creditType))] = true
+	}
+
+	return &mappedCsvBankTxnImporter{
+		mapping.DateColumn,
+		mapping.DescriptionColumn,
+		mapping.CreditColumn,
+		mapping.DebitColumn,
+		mapping.AmountColumn,
+		mapping.TypeColumn,
+		mapping.BalanceColumn,
+		creditTypes,
+		mapping.DateFormat,
+		decimalSeparator,
+		mapping.SkipRows,
+	}, nil
+}
+
+func (i *mappedCsvBankTxnImporter) columns() (columns []string) {
+	for _, column := range []string{i.dateColumn, i.descriptionColumn, i.creditColumn, i.debitColumn, i.amountColumn, i.typeColumn, i.balanceColumn} {
+		if len(column) > 0 {
+			columns = append(columns, column)
+		}
+	}
+
+	return columns
+}
+
+// Statements with preamble rows can't be recognised from their first line,
+// so they have to be picked with --bankFormat.
+func (i *mappedCsvBankTxnImporter) detect(header string) bool {
+	if i.skipRows > 0 {
+		return false
+	}
+
+	fields, err := csv.NewReader(strings.NewReader(header)).Read()
+	if err != nil {
+		return false
+	}
+
+	present := map[string]bool{}
+	for _, field := range fields {
+		present[strings.ToUpper(strings.TrimSpace(field))] = true
+	}
+
+	for _, column := range i.columns() {
+		if !present[strings.ToUpper(column)] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (i *mappedCsvBankTxnImporter) parseAmount(value string) (decimal.Decimal, error) {
+	value = strings.Replace(strings.TrimSpace(value), "£", "", -1)
+	if len(value) == 0 {
+		return decimal.Zero, nil
+	}
+
+	if i.decimalSeparator == "," {
+		value = strings.Replace(value, ".", "", -1)
+		value = strings.Replace(value, ",", ".", -1)
+	} else {
+		value = strings.Replace(value, ",", "", -1)
+	}
+
+	return decimal.NewFromString(value)
+}
+
+func (i *mappedCsvBankTxnImporter) importTxns(r io.Reader) (txns []*bankTxn, err error) {
+	csvReader := csv.NewReader(r)
+	csvReader.FieldsPerRecord = -1
+	csvReader.LazyQuotes = true
+	for row := 0; row < i.skipRows; row++ {
+		_, err := csvReader.Read()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to skip preamble row %v: %v", row+1, err)
+		}
+	}
+
+	header, err := csvReader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read header: %v", err)
+	}
+
+	columnIndexes := map[string]int{}
+	for index, name := range header {
+		columnIndexes[strings.ToUpper(strings.TrimSpace(name))] = index
+	}
+
+	for _, column := range i.columns() {
+		if _, ok := columnIndexes[strings.ToUpper(column)]; !ok {
+			return nil, fmt.Errorf("Column %v not found in header %v", column, header)
+		}
+	}
+
+	for {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		field := func(column string) string {
+			if len(column) == 0 {
+				return ""
+			}
+
+			index := columnIndexes[strings.ToUpper(column)]
+			if index >= len(record) {
+				return ""
+			}
+
+			return strings.TrimSpace(record[index])
+		}
+
+		var amount decimal.Decimal
+		if len(i.amountColumn) > 0 {
+			amount, err = i.parseAmount(field(i.amountColumn))
+		} else {
+			var credit, debit decimal.Decimal
+			credit, err = i.parseAmount(field(i.creditColumn))
+			if err == nil {
+				debit, err = i.parseAmount(field(i.debitColumn))
+			}
+
+			amount = credit.Sub(debit.Abs())
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse transaction amount (%v): %v", record, err)
+		}
+
+		date, err := time.Parse(i.dateFormat, field(i.dateColumn))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse transaction date (%v): %v", record, err)
+		}
+
+		txn, err := newBankTxn(date, field(i.descriptionColumn), amount.String())
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse transaction (%v): %v", record, err)
+		}
+
+		txn.txnType = field(i.typeColumn)
+		txn.credit = amount.IsPositive() && (len(i.creditTypes) == 0 || i.creditTypes[strings.ToUpper(txn.txnType)])
+		if len(field(i.balanceColumn)) > 0 {
+			balance, err := i.parseAmount(field(i.balanceColumn))
+			if err != nil {
+				return nil, fmt.Errorf("Failed to parse transaction balance (%v): %v", record, err)
+			}
+
+			txn.balance = &balance
+		}
+
+		for index, name := range header {
+			value := ""
+			if index < len(record) {
+				value = record[index]
+			}
+
+			txn.original = append(txn.original, txnColumn{strings.TrimSpace(name), value})
+		}
+		txns = append(txns, txn)
+	}
+
+	return txns, nil
+}
+
+func registerBankCsvMappings(mappings map[string]*YamlBankCsvMapping) error {
+	for name, mapping := range mappings {
+		if _, ok := bankTxnImporters[name]; ok || name == autoBankFormat {
+			return fmt.Errorf("Bank format %v is already defined", name)
+		}
+
+		importer, err := newMappedCsvBankTxnImporter(mapping)
+		if err != nil {
+			return fmt.Errorf("Invalid bank format %v: %v", name, err)
+		}
+
+		registerBankTxnImporter(name, importer)
+	}
+
+	return nil
+}
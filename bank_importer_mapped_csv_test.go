@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestMappedCsvBankTxnImporter(t *testing.T) {
+	testCases := []struct {
+		mapping      *YamlBankCsvMapping
+		statement    string
+		expectedTxns []*bankTxn
+	}{
+		{
+			&YamlBankCsvMapping{
+				DateColumn:        "Datum",
+				DescriptionColumn: "Omschrijving",
+				AmountColumn:      "Bedrag",
+				DateFormat:        "02/01/2006",
+				DecimalSeparator:  ",",
+				SkipRows:          2,
+			},
+			"Account: 12345678\nExported: 01/02/2018\nDatum,Omschrijving,Bedrag\n16/01/2018,Some Ref,\"1.018,50\"\n17/01/2018,Some Shop,\"-10,00\"\n",
+			[]*bankTxn{
+				{date: time.Date(2018, 1, 16, 0, 0, 0, 0, time.UTC), description: "SOME REF", amount: decimal.New(101850, -2), credit: true},
+				{date: time.Date(2018, 1, 17, 0, 0, 0, 0, time.UTC), description: "SOME SHOP", amount: decimal.New(-10, 0), credit: false},
+			},
+		},
+		{
+			&YamlBankCsvMapping{
+				DateColumn:        "Date",
+				DescriptionColumn: "Details",
+				CreditColumn:      "Credit",
+				DebitColumn:       "Debit",
+				TypeColumn:        "Type",
+				CreditTypes:       []string{"FPI", "BGC"},
+				DateFormat:        "2006-01-02",
+			},
+			"Date,Type,Details,Debit,Credit\n2018-01-16,FPI,Some Ref,,18.50\n2018-01-16,TFR,Internal,,100\n2018-01-17,DD,Some Shop,10.00,\n",
+			[]*bankTxn{
+				{date: time.Date(2018, 1, 16, 0, 0, 0, 0, time.UTC), description: "SOME REF", amount: decimal.New(185, -1), credit: true},
+				{date: time.Date(2018, 1, 16, 0, 0, 0, 0, time.UTC), description: "INTERNAL", amount: decimal.New(100, 0), credit: false},
+				{date: time.Date(2018, 1, 17, 0, 0, 0, 0, time.UTC), description: "SOME SHOP", amount: decimal.New(-10, 0), credit: false},
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		importer, err := newMappedCsvBankTxnImporter(testCase.mapping)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		actualTxns, err := importer.importTxns(strings.NewReader(testCase.statement))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		if len(actualTxns) != len(testCase.expectedTxns) {
+			t.Fatalf("Txn counts are not the same (%v, %v)", len(actualTxns), len(testCase.expectedTxns))
+		}
+
+		for i := range testCase.expectedTxns {
+			if !testCase.expectedTxns[i].equal(actualTxns[i]) || testCase.expectedTxns[i].credit != actualTxns[i].credit {
+				t.Fatalf("%v != %v", testCase.expectedTxns[i], actualTxns[i])
+			}
+		}
+	}
+}
+
+func TestNewMappedCsvBankTxnImporterValidation(t *testing.T) {
+	_, err := newMappedCsvBankTxnImporter(&YamlBankCsvMapping{
+		DateColumn:        "Date",
+		DescriptionColumn: "Details",
+		AmountColumn:      "Amount",
+		CreditColumn:      "Credit",
+		DateFormat:        "2006-01-02",
+	})
+	if err == nil {
+		t.Fatalf("Expected an error when mixing amountColumn and creditColumn")
+	}
+}
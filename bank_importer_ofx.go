@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerBankTxnImporter("ofx", &ofxBankTxnImporter{})
+}
+
+const ofxDateFormat = "20060102"
+
+// The usual STMTTRN fields, in the order they're written out. Anything else
+// follows in alphabetical order.
+var ofxTxnFields = []string{"DTPOSTED", "TRNTYPE", "NAME", "MEMO", "TRNAMT", "FITID", "CHECKNUM", "REFNUM"}
+
+var ofxEntityReplacer = strings.NewReplacer("&lt;", "<", "&gt;", ">", "&quot;", "\"", "&apos;", "'", "&amp;", "&")
+
+/*
+Handles OFX 1.x (SGML, where elements have no closing tags) and OFX 2.x (XML)
+statements, including QFX which is OFX with extra Intuit elements. Both are
+read as a flat stream of tags, so only the STMTTRN aggregates matter.
+*/
+type ofxBankTxnImporter struct{}
+
+func (i *ofxBankTxnImporter) detect(header string) bool {
+	upperHeader := strings.ToUpper(header)
+	for _, prefix := range []string{"OFXHEADER", "<OFX>", "<?OFX", "<?XML"} {
+		if strings.HasPrefix(upperHeader, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type ofxTag struct {
+	name    string
+	closing bool
+	value   string
+}
+
+func tokeniseOfx(content string) (tags []ofxTag) {
+	for {
+		start := strings.Index(content, "<")
+		if start < 0 {
+			return tags
+		}
+
+		end := strings.Index(content[start:], ">")
+		if end < 0 {
+			return tags
+		}
+
+		name := strings.ToUpper(strings.TrimSpace(content[start+1 : start+end]))
+		content = content[start+end+1:]
+		next := strings.Index(content, "<")
+		if next < 0 {
+			next = len(content)
+		}
+
+		value := ofxEntityReplacer.Replace(strings.TrimSpace(content[:next]))
+		if strings.HasPrefix(name, "?") || strings.HasPrefix(name, "!") {
+			continue
+		}
+
+		if strings.HasPrefix(name, "/") {
+			tags = append(tags, ofxTag{name[1:], true, ""})
+		} else {
+			tags = append(tags, ofxTag{name, false, value})
+		}
+	}
+}
+
+func newOfxBankTxn(fields map[string]string) (*bankTxn, error) {
+	postedDate := fields["DTPOSTED"]
+	if len(postedDate) < len(ofxDateFormat) {
+		return nil, fmt.Errorf("Missing or short DTPOSTED %q", postedDate)
+	}
+
+	date, err := time.Parse(ofxDateFormat, postedDate[:len(ofxDateFormat)])
+	if err != nil {
+		return nil, err
+	}
+
+	description := strings.TrimSpace(fields["NAME"] + " " + fields["MEMO"])
+	txn, err := newBankTxn(date, description, fields["TRNAMT"])
+	if err != nil {
+		return nil, err
+	}
+
+	txn.fitID = fields["FITID"]
+	txn.txnType = fields["TRNTYPE"]
+	txn.credit = txn.amount.IsPositive()
+
+	known := map[string]bool{}
+	for _, name := range ofxTxnFields {
+		known[name] = true
+		if value, ok := fields[name]; ok {
+			txn.original = append(txn.original, txnColumn{name, value})
+		}
+	}
+
+	others := []string{}
+	for name := range fields {
+		if !known[name] {
+			others = append(others, name)
+		}
+	}
+
+	sort.Strings(others)
+	for _, name := range others {
+		txn.original = append(txn.original, txnColumn{name, fields[name]})
+	}
+
+	return txn, nil
+}
+
+func (i *ofxBankTxnImporter) importTxns(r io.Reader) (txns []*bankTxn, err error) {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]string
+	finishTxn := func() error {
+		if fields == nil {
+			return nil
+		}
+
+		txn, err := newOfxBankTxn(fields)
+		if err != nil {
+			return fmt.Errorf("Failed to parse transaction (%v): %v", fields, err)
+		}
+
+		fields = nil
+		txns = append(txns, txn)
+
+		return nil
+	}
+
+	for _, tag := range tokeniseOfx(string(content)) {
+		switch {
+		case tag.name == "STMTTRN":
+			err = finishTxn()
+			if err != nil {
+				return nil, err
+			}
+
+			if !tag.closing {
+				fields = map[string]string{}
+			}
+		case tag.name == "BANKTRANLIST" && tag.closing:
+			err = finishTxn()
+			if err != nil {
+				return nil, err
+			}
+		case fields != nil && !tag.closing:
+			fields[tag.name] = tag.value
+		}
+	}
+
+	err = finishTxn()
+	if err != nil {
+		return nil, err
+	}
+
+	return txns, nil
+}
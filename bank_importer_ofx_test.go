@@ -0,0 +1,98 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const testSgmlOfxStatement = `OFXHEADER:100
+DATA:OFXSGML
+VERSION:102
+
+<OFX>
+<BANKMSGSRSV1><STMTTRNRS><STMTRS>
+<BANKTRANLIST>
+<DTSTART>20180101
+<DTEND>20180131
+<STMTTRN>
+<TRNTYPE>CREDIT
+<DTPOSTED>20180116120000[0:GMT]
+<TRNAMT>18.50
+<FITID>201801160001
+<NAME>J BLOGGS
+<MEMO>SOME REF
+</STMTTRN>
+<STMTTRN>
+<TRNTYPE>DEBIT
+<DTPOSTED>20180117
+<TRNAMT>-10.00
+<FITID>201801170001
+<NAME>SOME SHOP
+</STMTTRN>
+</BANKTRANLIST>
+</STMTRS></STMTTRNRS></BANKMSGSRSV1>
+</OFX>
+`
+
+const testXmlOfxStatement = `<?xml version="1.0" encoding="UTF-8" standalone="no"?>
+<?OFX OFXHEADER="200" VERSION="211" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>
+<OFX>
+  <BANKMSGSRSV1><STMTTRNRS><STMTRS>
+    <BANKTRANLIST>
+      <STMTTRN>
+        <TRNTYPE>CREDIT</TRNTYPE>
+        <DTPOSTED>20180116</DTPOSTED>
+        <TRNAMT>30.00</TRNAMT>
+        <FITID>ABC123</FITID>
+        <NAME>SMITH &amp; JONES</NAME>
+      </STMTTRN>
+    </BANKTRANLIST>
+  </STMTRS></STMTTRNRS></BANKMSGSRSV1>
+</OFX>
+`
+
+func TestOfxBankTxnImporter(t *testing.T) {
+	testCases := []struct {
+		statement    string
+		expectedTxns []*bankTxn
+	}{
+		{
+			testSgmlOfxStatement,
+			[]*bankTxn{
+				{date: time.Date(2018, 1, 16, 0, 0, 0, 0, time.UTC), description: "J BLOGGS SOME REF", amount: decimal.New(185, -1), fitID: "201801160001", credit: true},
+				{date: time.Date(2018, 1, 17, 0, 0, 0, 0, time.UTC), description: "SOME SHOP", amount: decimal.New(-10, 0), fitID: "201801170001", credit: false},
+			},
+		},
+		{
+			testXmlOfxStatement,
+			[]*bankTxn{
+				{date: time.Date(2018, 1, 16, 0, 0, 0, 0, time.UTC), description: "SMITH & JONES", amount: decimal.New(30, 0), fitID: "ABC123", credit: true},
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		importer, err := selectBankTxnImporter(autoBankFormat, []byte(testCase.statement))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		actualTxns, err := importer.importTxns(strings.NewReader(testCase.statement))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		if len(actualTxns) != len(testCase.expectedTxns) {
+			t.Fatalf("Txn counts are not the same (%v, %v)", len(actualTxns), len(testCase.expectedTxns))
+		}
+
+		for i := range testCase.expectedTxns {
+			if !testCase.expectedTxns[i].equal(actualTxns[i]) || testCase.expectedTxns[i].fitID != actualTxns[i].fitID || testCase.expectedTxns[i].credit != actualTxns[i].credit {
+				t.Fatalf("%v != %v", testCase.expectedTxns[i], actualTxns[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const testCsvStatement = `Date,Type,Description,Paid Out,Paid In,Balance
+15-Jan-18,,BALANCE BROUGHT FORWARD, , ,12327.17
+16-Jan-18,CR,Some Ref , ,18.5,12345.67
+17-Jan-18,DR,Some Shop ,10, ,12335.67
+`
+
+func TestSelectBankTxnImporter(t *testing.T) {
+	importer, err := selectBankTxnImporter(autoBankFormat, []byte("\ufeff\n"+testCsvStatement))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if importer != bankTxnImporters["csv"] {
+		t.Fatalf("Detected the wrong importer: %v", importer)
+	}
+
+	_, err = selectBankTxnImporter(autoBankFormat, []byte("Something,Else\n"))
+	if err == nil {
+		t.Fatalf("Expected an error for an unrecognised header")
+	}
+
+	_, err = selectBankTxnImporter("no-such-bank", []byte(testCsvStatement))
+	if err == nil {
+		t.Fatalf("Expected an error for an unknown format")
+	}
+}
+
+func TestCsvBankTxnImporter(t *testing.T) {
+	expectedTxns := []*bankTxn{
+		{date: time.Date(2018, 1, 16, 0, 0, 0, 0, time.UTC), description: "SOME REF", amount: decimal.New(185, -1), txnType: "CR", credit: true},
+		{date: time.Date(2018, 1, 17, 0, 0, 0, 0, time.UTC), description: "SOME SHOP", amount: decimal.New(-10, 0), txnType: "DR", credit: false},
+	}
+
+	actualTxns, err := bankTxnImporters["csv"].importTxns(strings.NewReader(testCsvStatement))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(actualTxns) != len(expectedTxns) {
+		t.Fatalf("Txn counts are not the same (%v, %v)", len(actualTxns), len(expectedTxns))
+	}
+
+	for i := range expectedTxns {
+		if !expectedTxns[i].equal(actualTxns[i]) || expectedTxns[i].txnType != actualTxns[i].txnType || expectedTxns[i].credit != actualTxns[i].credit {
+			t.Fatalf("%v != %v", expectedTxns[i], actualTxns[i])
+		}
+	}
+
+	expectedColumns := []string{"Date", "Type", "Description", "Paid Out", "Paid In", "Balance"}
+	if names := originalColumnNames(actualTxns); !reflect.DeepEqual(names, expectedColumns) {
+		t.Fatalf("%v != %v", names, expectedColumns)
+	}
+}
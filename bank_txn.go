@@ -1,27 +1,56 @@
 package main
 
 import (
+	"fmt"
 	"strings"
+	"time"
 
 	"github.com/shopspring/decimal"
 )
 
+// A column from the bank's statement, kept as the bank gave it.
+type txnColumn struct {
+	name  string
+	value string
+}
+
 type bankTxn struct {
+	date        time.Time
 	description string
 	amount      decimal.Decimal
+	fitID       string
+	txnType     string
+	credit      bool
+	original    []txnColumn
+	balance     *decimal.Decimal
 }
 
-func newBankTxn(description, amount string) (*bankTxn, error) {
+func newBankTxn(date time.Time, description, amount string) (*bankTxn, error) {
 	amt, err := decimal.NewFromString(amount)
 	if err != nil {
 		return nil, err
 	}
 
-	return &bankTxn{strings.ToUpper(strings.TrimSpace(description)), amt}, nil
+	return &bankTxn{date: date, description: strings.ToUpper(strings.TrimSpace(description)), amount: amt}, nil
+}
+
+// Transactions that didn't come from a statement, such as those loaded back
+// from the ledger, only have the fields we kept.
+func (t *bankTxn) originalColumns() []txnColumn {
+	if len(t.original) > 0 {
+		return t.original
+	}
+
+	return []txnColumn{
+		{"Date", t.date.Format(ledgerDateFormat)},
+		{"Type", t.txnType},
+		{"Description", t.description},
+		{"Amount", t.amount.String()},
+	}
 }
 
 func (t1 *bankTxn) equal(t2 *bankTxn) bool {
-	return t1.description == t2.description && t1.amount.Equal(t2.amount)
+	return t1.date.Equal(t2.date) && t1.description == t2.description && t1.amount.Equal(t2.amount)
 }
 
 func filterInterestingTxns(source []*bankTxn, interestingAmounts []decimal.Decimal) (interestedTxns []*bankTxn, junkTxns []*bankTxn) {
@@ -42,3 +71,67 @@ func filterInterestingTxns(source []*bankTxn, interestingAmounts []decimal.Decim
 
 	return interestedTxns, junkTxns
 }
+
+// Importers return every row on the statement, and mark which are credits.
+func filterCreditTxns(source []*bankTxn) (creditTxns []*bankTxn, otherTxns []*bankTxn) {
+	for _, txn := range source {
+		if txn.credit {
+			creditTxns = append(creditTxns, txn)
+		} else {
+			otherTxns = append(otherTxns, txn)
+		}
+	}
+
+	return creditTxns, otherTxns
+}
+
+const (
+	txnTypeCredit = "credit"
+	txnTypeDebit  = "debit"
+	txnTypeIgnore = "ignore"
+)
+
+var txnTypeHandlings = []string{txnTypeCredit, txnTypeDebit, txnTypeIgnore}
+
+// Maps the bank's transaction type codes to how they're handled. Codes that
+// aren't mapped keep whatever the importer decided.
+type txnTypeMapping map[string]string
+
+func newTxnTypeMapping(types map[string]string) (txnTypeMapping, error) {
+	mapping := txnTypeMapping{}
+	for code, handling := range types {
+		handling = strings.ToLower(strings.TrimSpace(handling))
+		switch handling {
+		case txnTypeCredit, txnTypeDebit, txnTypeIgnore:
+			mapping[strings.ToUpper(strings.TrimSpace(code))] = handling
+		default:
+			return nil, fmt.Errorf("Unknown handling %v for transaction type %v (expected one of %v)", handling, code, txnTypeHandlings)
+		}
+	}
+
+	return mapping, nil
+}
+
+func (m txnTypeMapping) handling(txn *bankTxn) (string, bool) {
+	handling, ok := m[strings.ToUpper(strings.TrimSpace(txn.txnType))]
+	return handling, ok
+}
+
+func (m txnTypeMapping) apply(source []*bankTxn) (txns []*bankTxn, ignoredTxns []*bankTxn) {
+	for _, txn := range source {
+		handling, _ := m.handling(txn)
+		switch handling {
+		case txnTypeCredit:
+			txn.credit = true
+		case txnTypeDebit:
+			txn.credit = false
+		case txnTypeIgnore:
+			ignoredTxns = append(ignoredTxns, txn)
+			continue
+		}
+
+		txns = append(txns, txn)
+	}
+
+	return txns, ignoredTxns
+}
@@ -8,8 +8,8 @@ import (
 
 func TestFilterInterestingTxns(t *testing.T) {
 	allTxns := []*bankTxn{
-		{"JoeBloggs", decimal.New(15, 1)},
-		{"JaneDoe", decimal.New(1234, -2)},
+		{description: "JoeBloggs", amount: decimal.New(15, 1)},
+		{description: "JaneDoe", amount: decimal.New(1234, -2)},
 	}
 	interestedAmounts := []decimal.Decimal{
 		decimal.New(15, 1),
@@ -18,10 +18,10 @@ func TestFilterInterestingTxns(t *testing.T) {
 		decimal.New(30, 1),
 	}
 	expectedInterestingTxns := []*bankTxn{
-		{"JoeBloggs", decimal.New(15, 1)},
+		{description: "JoeBloggs", amount: decimal.New(15, 1)},
 	}
 	expectedJunkTxns := []*bankTxn{
-		{"JaneDoe", decimal.New(1234, -2)},
+		{description: "JaneDoe", amount: decimal.New(1234, -2)},
 	}
 
 	actualInterestingTxns, actualJunkTxns := filterInterestingTxns(allTxns, interestedAmounts)
@@ -64,3 +64,43 @@ func TestFilterInterestingTxns(t *testing.T) {
 		}
 	}
 }
+
+func TestTxnTypeMapping(t *testing.T) {
+	mapping, err := newTxnTypeMapping(map[string]string{"fpi": "Credit", "BGC": "credit", "TFR": "ignore", "CR": "debit"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	txns := []*bankTxn{
+		{description: "FASTER", txnType: "FPI", credit: false},
+		{description: "GIRO", txnType: " bgc ", credit: false},
+		{description: "TRANSFER", txnType: "TFR", credit: true},
+		{description: "OLD CREDIT", txnType: "CR", credit: true},
+		{description: "UNMAPPED", txnType: "SO", credit: true},
+	}
+
+	kept, ignored := mapping.apply(txns)
+	if len(ignored) != 1 || ignored[0].description != "TRANSFER" {
+		t.Fatalf("%v != %v", ignored, txns[2])
+	}
+
+	expectedCredit := map[string]bool{"FASTER": true, "GIRO": true, "OLD CREDIT": false, "UNMAPPED": true}
+	if len(kept) != len(expectedCredit) {
+		t.Fatalf("%v != %v", len(kept), len(expectedCredit))
+	}
+
+	for _, txn := range kept {
+		if txn.credit != expectedCredit[txn.description] {
+			t.Fatalf("%v credit %v != %v", txn.description, txn.credit, expectedCredit[txn.description])
+		}
+
+		if len(txn.txnType) == 0 {
+			t.Fatalf("%v lost its type", txn.description)
+		}
+	}
+
+	_, err = newTxnTypeMapping(map[string]string{"SO": "maybe"})
+	if err == nil {
+		t.Fatalf("Expected an error for an unknown handling")
+	}
+}
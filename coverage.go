@@ -0,0 +1,57 @@
+package main
+
+import (
+	"time"
+)
+
+type coverageWindow struct {
+	paid  time.Time
+	from  time.Time
+	until time.Time
+}
+
+// A payment covers the whole of the month it's made in, and the months after
+// it when it pays for more than one, whatever day it arrives.
+func newCoverageWindow(txn *bankTxn, months int) coverageWindow {
+	from, _ := monthPeriod(txn.date)
+	return coverageWindow{txn.date, from, from.AddDate(0, months, 0)}
+}
+
+func (w coverageWindow) overlaps(from, until time.Time) bool {
+	return w.from.Before(until) && from.Before(w.until)
+}
+
+func monthPeriod(month time.Time) (from, until time.Time) {
+	from = time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return from, from.AddDate(0, 1, 0)
+}
+
+/*
+Each payment is checked against the fee schedule in force when it was made,
+so an annual payment made before a fee change keeps covering its member. A
+payment that's been refunded in full covers nobody.
+*/
+func identifyCoverage(entries []*ledgerEntry, schedules []*feeSchedule) (coverage map[string][]coverageWindow) {
+	coverage = make(map[string][]coverageWindow)
+	refunded := identifyRefundedPayments(entries)
+	for _, entry := range entries {
+		if !entry.isMembershipPayment() {
+			continue
+		}
+
+		months := 1
+		schedule, err := selectFeeSchedule(schedules, entry.txn.date)
+		if err == nil {
+			months = schedule.coverageMonths(entry.txn.amount)
+		}
+
+		window := newCoverageWindow(entry.txn, months)
+		for _, memberID := range entry.memberIDs {
+			if !refunded[memberPayment{entry, memberID}] {
+				coverage[memberID] = append(coverage[memberID], window)
+			}
+		}
+	}
+
+	return coverage
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestCoverageWindowMidMonth(t *testing.T) {
+	schedules := []*feeSchedule{
+		{
+			effectiveFrom:  time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC),
+			correctAmounts: []decimal.Decimal{decimal.New(185, -1)},
+		},
+	}
+	entries := []*ledgerEntry{
+		newTestLedgerEntry(2018, time.January, 15, "STOPPED", decimal.New(185, -1), "A1"),
+	}
+
+	coverage := identifyCoverage(entries, schedules)
+	window := coverage["A1"][0]
+	if !window.from.Equal(time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)) || !window.until.Equal(time.Date(2018, 2, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Window not aligned to January: %v - %v", window.from, window.until)
+	}
+
+	testCases := []struct {
+		month  time.Month
+		status string
+	}{
+		{time.January, statusJoined},
+		{time.February, statusLapsing},
+		{time.April, statusLapsed},
+	}
+
+	for _, testCase := range testCases {
+		from, until := monthPeriod(time.Date(2018, testCase.month, 1, 0, 0, 0, 0, time.UTC))
+		statuses := identifyMemberStatuses(coverage, from, until, 30*24*time.Hour)
+		if len(statuses) != 1 || statuses[0].status != testCase.status {
+			t.Fatalf("%v: %v != %v", testCase.month, statuses[0].status, testCase.status)
+		}
+
+		if !statuses[0].lastPaid.Equal(time.Date(2018, 1, 15, 0, 0, 0, 0, time.UTC)) {
+			t.Fatalf("%v != %v", statuses[0].lastPaid, entries[0].txn.date)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	decisionIgnore        = "ignore"
+	decisionNotMembership = "not membership"
+)
+
+type txnDecision struct {
+	txn       *bankTxn
+	memberIDs []string
+	decision  string
+}
+
+/*
+An ignoreRule without a transaction ignores every transaction with that
+reference (it isn't a membership payment). Otherwise it ignores just the one
+transaction with that reference, date and amount.
+*/
+type ignoreRule struct {
+	reference string
+	txn       *bankTxn
+	reason    string
+}
+
+func (r *ignoreRule) matches(txn *bankTxn) bool {
+	if r.reference != txn.reference() {
+		return false
+	}
+
+	return r.txn == nil || (r.txn.date.Equal(txn.date) && r.txn.amount.Equal(txn.amount))
+}
+
+type ignoreList struct {
+	rules []*ignoreRule
+}
+
+func (il *ignoreList) match(txn *bankTxn) (*ignoreRule, bool) {
+	for _, rule := range il.rules {
+		if rule.matches(txn) {
+			return rule, true
+		}
+	}
+
+	return nil, false
+}
+
+func (il *ignoreList) filter(txns []*bankTxn) (keptTxns []*bankTxn, ignoredTxns []*bankTxn) {
+	for _, txn := range txns {
+		if _, ignored := il.match(txn); ignored {
+			ignoredTxns = append(ignoredTxns, txn)
+		} else {
+			keptTxns = append(keptTxns, txn)
+		}
+	}
+
+	return keptTxns, ignoredTxns
+}
+
+/*
+Turns a filled in decisions sheet into new reference mappings and ignore
+rules. Every row is checked before anything is returned, so a sheet with any
+problems can be fixed and re-imported as a whole.
+*/
+func resolveDecisions(decisions []*txnDecision, members map[string]*Member, references *referenceMatcher) (newReferences []*MemberReference, newIgnoreRules []*ignoreRule, problems []error) {
+	resolved := map[string]string{}
+	for _, decision := range decisions {
+		reference := decision.txn.reference()
+		switch {
+		case len(decision.memberIDs) > 0 && len(decision.decision) > 0:
+			problems = append(problems, fmt.Errorf("%v: has both member IDs and a decision", reference))
+		case len(decision.memberIDs) > 0:
+			if rule, ok := references.matchTxn(decision.txn); ok {
+				problems = append(problems, fmt.Errorf("%v: already mapped by %v", reference, rule))
+				continue
+			}
+
+			unknown := []string{}
+			for _, memberID := range decision.memberIDs {
+				if _, ok := members[memberID]; !ok {
+					unknown = append(unknown, memberID)
+				}
+			}
+
+			if len(unknown) > 0 {
+				problems = append(problems, fmt.Errorf("%v: unknown member IDs %v", reference, unknown))
+				continue
+			}
+
+			memberIDs := strings.Join(decision.memberIDs, "|")
+			if previous, ok := resolved[reference]; ok {
+				if previous != memberIDs {
+					problems = append(problems, fmt.Errorf("%v: mapped to both %v and %v", reference, previous, memberIDs))
+				}
+
+				continue
+			}
+
+			resolved[reference] = memberIDs
+			newReferences = append(newReferences, &MemberReference{Reference: reference, MemberIDs: memberIDs})
+		case decision.decision == decisionIgnore:
+			newIgnoreRules = append(newIgnoreRules, &ignoreRule{reference, decision.txn, decisionIgnore})
+		case decision.decision == decisionNotMembership:
+			newIgnoreRules = append(newIgnoreRules, &ignoreRule{reference: reference, reason: decisionNotMembership})
+		case len(decision.decision) > 0:
+			problems = append(problems, fmt.Errorf("%v: unknown decision %q (expected %q or %q)", reference, decision.decision, decisionIgnore, decisionNotMembership))
+		}
+	}
+
+	return newReferences, newIgnoreRules, problems
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestResolveDecisions(t *testing.T) {
+	members := map[string]*Member{
+		"A123456": {"A123456", "Mr", "Joe", "Blogg", "joebloggs@example.com"},
+		"A789012": {"A789012", "Ms", "Jane", "Doe", "janedoe@example.com"},
+	}
+	refs := newReferenceMatcher()
+	refs.add(matchLiteral, "ALREADY MAPPED", []string{"A123456"})
+	date := time.Date(2018, 1, 16, 0, 0, 0, 0, time.UTC)
+	newTxn := func(description string) *bankTxn {
+		return &bankTxn{date: date, description: description, amount: decimal.New(185, -1)}
+	}
+
+	decisions := []*txnDecision{
+		{newTxn("J BLOGGS"), []string{"A123456"}, ""},
+		{newTxn("J BLOGGS"), []string{"A123456"}, ""},
+		{newTxn("RAFFLE"), nil, decisionNotMembership},
+		{newTxn("REFUND"), nil, decisionIgnore},
+		{newTxn("NOT DECIDED"), nil, ""},
+	}
+
+	newReferences, newIgnoreRules, problems := resolveDecisions(decisions, members, refs)
+	if len(problems) > 0 {
+		t.Fatalf("Unexpected problems: %v", problems)
+	}
+
+	if len(newReferences) != 1 || newReferences[0].Reference != "J BLOGGS" || newReferences[0].MemberIDs != "A123456" {
+		t.Fatalf("Unexpected references: %v", newReferences)
+	}
+
+	if len(newIgnoreRules) != 2 || newIgnoreRules[0].txn != nil || newIgnoreRules[1].txn == nil {
+		t.Fatalf("Unexpected ignore rules: %v", newIgnoreRules)
+	}
+
+	badDecisions := []*txnDecision{
+		{newTxn("ALREADY MAPPED"), []string{"A789012"}, ""},
+		{newTxn("UNKNOWN MEMBER"), []string{"A000000"}, ""},
+		{newTxn("BOTH"), []string{"A123456"}, decisionIgnore},
+		{newTxn("CONFLICT"), []string{"A123456"}, ""},
+		{newTxn("CONFLICT"), []string{"A789012"}, ""},
+		{newTxn("TYPO"), nil, "ignroe"},
+	}
+
+	_, _, problems = resolveDecisions(badDecisions, members, refs)
+	if len(problems) != 5 {
+		t.Fatalf("Expected 5 problems, got %v", problems)
+	}
+}
+
+func TestIgnoreListFilter(t *testing.T) {
+	date := time.Date(2018, 1, 16, 0, 0, 0, 0, time.UTC)
+	il := &ignoreList{[]*ignoreRule{
+		{"RAFFLE", nil, decisionNotMembership},
+		{"REFUND", &bankTxn{date: date, description: "REFUND", amount: decimal.New(185, -1)}, decisionIgnore},
+	}}
+	txns := []*bankTxn{
+		{date: date, description: "FP RAFFLE", amount: decimal.New(5, 0)},
+		{date: date, description: "REFUND", amount: decimal.New(185, -1)},
+		{date: date.AddDate(0, 1, 0), description: "REFUND", amount: decimal.New(185, -1)},
+	}
+
+	keptTxns, ignoredTxns := il.filter(txns)
+	if len(keptTxns) != 1 || keptTxns[0] != txns[2] {
+		t.Fatalf("Unexpected kept txns: %v", keptTxns)
+	}
+
+	if len(ignoredTxns) != 2 {
+		t.Fatalf("Unexpected ignored txns: %v", ignoredTxns)
+	}
+}
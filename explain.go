@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	outcomeNotCredit   = "not-credit"
+	outcomeOutOfPeriod = "out-of-period"
+	stepNotReached     = "-"
+)
+
+/*
+A txnTrace records how a single transaction got its classification, one
+column per step of loadAndFilterTxns. Steps the transaction never reached
+are left as stepNotReached.
+*/
+type txnTrace struct {
+	txn             *bankTxn
+	period          string
+	typeFilter      string
+	ignoreList      string
+	amountFilter    string
+	correctAmount   string
+	referenceLookup string
+	memberLookup    string
+	outcome         string
+}
+
+func passed(format string, a ...interface{}) string {
+	return "pass: " + fmt.Sprintf(format, a...)
+}
+
+func failed(format string, a ...interface{}) string {
+	return "fail: " + fmt.Sprintf(format, a...)
+}
+
+// Repeats the checks made by loadAndFilterTxns for a single transaction.
+func (m *membership) explainTxn(txn *bankTxn, schedule *feeSchedule, referenceFirst bool) *txnTrace {
+	trace := &txnTrace{txn, stepNotReached, stepNotReached, stepNotReached, stepNotReached, stepNotReached, stepNotReached, stepNotReached, ""}
+	if handling, _ := m.txnTypes.handling(txn); handling == txnTypeIgnore {
+		trace.typeFilter = failed("type %q is ignored", txn.txnType)
+		trace.outcome = txnIgnored
+		return trace
+	}
+
+	if !txn.credit {
+		trace.typeFilter = failed("type %q is not a credit", txn.txnType)
+		trace.outcome = outcomeNotCredit
+		if _, ok := m.ignoreList.match(txn); ok {
+			return trace
+		}
+
+		if rule, ok := m.references.matchTxn(txn); ok {
+			trace.referenceLookup = passed("%v matched %v", txn.reference(), rule)
+			trace.outcome = txnRefund
+		}
+
+		return trace
+	}
+
+	trace.typeFilter = passed("type %q is a credit", txn.txnType)
+	if rule, ok := m.ignoreList.match(txn); ok {
+		trace.ignoreList = failed("%v is on the ignore list (%v)", rule.reference, rule.reason)
+		trace.outcome = txnIgnored
+		return trace
+	}
+
+	trace.ignoreList = passed("not on the ignore list")
+	isFeeAmount := containsAmount(schedule.allAmounts(), txn.amount)
+	partialPayments, _ := identifyPartialPayments([]*bankTxn{txn}, m.references, schedule)
+	isPartialPayment := len(partialPayments) > 0
+	lookupReference := func() (*referenceRule, bool) {
+		rule, ok := m.references.matchTxn(txn)
+		if ok {
+			trace.referenceLookup = passed("%v matched %v", txn.reference(), rule)
+		} else {
+			candidates := []string{}
+			for _, suggestion := range suggestReferences(txn, m.references) {
+				candidates = append(candidates, fmt.Sprintf("%v (%.2f)", suggestion.reference, suggestion.score))
+			}
+
+			trace.referenceLookup = failed("%v matched no reference; closest %v", txn.reference(), strings.Join(candidates, ", "))
+		}
+
+		return rule, ok
+	}
+
+	var rule *referenceRule
+	known := false
+	if referenceFirst {
+		rule, known = lookupReference()
+	}
+
+	if isPartialPayment {
+		trace.amountFilter = passed("%v is short of a household fee, so kept whatever the amount", txn.amount)
+	} else if isFeeAmount {
+		trace.amountFilter = passed("%v is a fee amount", txn.amount)
+	} else {
+		trace.amountFilter = failed("%v is not one of the fee amounts %v", txn.amount, schedule.allAmounts())
+		if known {
+			trace.outcome = txnWrongAmount
+		} else {
+			trace.outcome = txnIgnored
+		}
+
+		return trace
+	}
+
+	if !referenceFirst {
+		rule, known = lookupReference()
+	}
+
+	isCorrectAmount, expected, err := schedule.checkAmount(txn.amount, rule)
+	if err != nil {
+		trace.correctAmount = failed("%v", err)
+	} else if isCorrectAmount {
+		trace.correctAmount = passed("%v is a correct amount", txn.amount)
+	} else if expected != nil {
+		trace.correctAmount = failed("%v is not the %v fee of %v", txn.amount, rule.feeCategory, *expected)
+	} else {
+		trace.correctAmount = failed("%v is a legacy amount, not one of %v", txn.amount, schedule.allCorrectAmounts())
+	}
+
+	if !known {
+		trace.outcome = txnUnmatched
+		return trace
+	}
+
+	matched, unmatched := matchMembers(m.members, rule.memberIDs)
+	if len(unmatched) > 0 {
+		trace.memberLookup = failed("no member details for %v", strings.Join(unmatched, "|"))
+	} else if !isCorrectAmount && expected != nil && len(rule.memberIDs) > 1 && txn.amount.LessThan(*expected) {
+		payment := &partialPayment{txn, *expected, allocatePayment(txn.amount, *expected, rule.memberIDs)}
+		trace.memberLookup = failed("partial household payment covers %v of %v members", len(payment.coveredMemberIDs()), len(rule.memberIDs))
+	} else {
+		trace.memberLookup = passed("found %v members", len(matched))
+	}
+
+	if isCorrectAmount {
+		trace.outcome = txnMatched
+	} else {
+		trace.outcome = txnIncorrect
+	}
+
+	return trace
+}
+
+/*
+Explains each transaction as the run saw it. Rows outside the month are
+stopped at the period step when they're excluded, and the outcome is the
+classification the run recorded wherever there is one, so the trace can't
+disagree with the reports.
+*/
+func (m *membership) explainTxns(txns []*bankTxn, am *activeMembers, rc *runConfig) (traces []*txnTrace) {
+	from, until := monthPeriod(rc.month)
+	outOfPeriod := make(map[*bankTxn]bool)
+	for _, txn := range am.txns.outOfPeriod {
+		outOfPeriod[txn] = true
+	}
+
+	for _, txn := range txns {
+		if outOfPeriod[txn] && rc.excludeOutOfPeriod {
+			trace := &txnTrace{txn, stepNotReached, stepNotReached, stepNotReached, stepNotReached, stepNotReached, stepNotReached, stepNotReached, outcomeOutOfPeriod}
+			trace.period = failed("%v is outside %v to %v", txn.date.Format(ledgerDateFormat), from.Format(ledgerDateFormat), until.AddDate(0, 0, -1).Format(ledgerDateFormat))
+			traces = append(traces, trace)
+			continue
+		}
+
+		trace := m.explainTxn(txn, am.feeSchedule, rc.referenceFirst)
+		if outOfPeriod[txn] {
+			trace.period = passed("%v is outside %v to %v, but out-of-period rows are kept", txn.date.Format(ledgerDateFormat), from.Format(ledgerDateFormat), until.AddDate(0, 0, -1).Format(ledgerDateFormat))
+		} else {
+			trace.period = passed("%v is inside %v to %v", txn.date.Format(ledgerDateFormat), from.Format(ledgerDateFormat), until.AddDate(0, 0, -1).Format(ledgerDateFormat))
+		}
+
+		if entry, ok := am.txns.classified[txn]; ok {
+			trace.outcome = entry.classification
+		}
+
+		traces = append(traces, trace)
+	}
+
+	return traces
+}
+
+func filterTxnsByReference(txns []*bankTxn, reference string) (filteredTxns []*bankTxn) {
+	reference = normaliseReference(reference)
+	for _, txn := range txns {
+		if txn.reference() == reference {
+			filteredTxns = append(filteredTxns, txn)
+		}
+	}
+
+	return filteredTxns
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestExplainTxn(t *testing.T) {
+	refs := newReferenceMatcher()
+	refs.add(matchLiteral, "JOE BLOGGS", []string{"A123456"})
+	refs.add(matchLiteral, "LOST MEMBER", []string{"A000000"})
+	m := &membership{
+		references: refs,
+		members: map[string]*Member{
+			"A123456": {"A123456", "Mr", "Joe", "Blogg", "joebloggs@example.com"},
+		},
+		ignoreList: &ignoreList{[]*ignoreRule{{"RAFFLE", nil, decisionNotMembership}}},
+	}
+	schedule := &feeSchedule{
+		correctAmounts: []decimal.Decimal{decimal.New(185, -1)},
+		legacyAmounts:  []decimal.Decimal{decimal.New(165, -1)},
+	}
+	date := time.Date(2018, 1, 16, 0, 0, 0, 0, time.UTC)
+	newTxn := func(description string, amount decimal.Decimal, credit bool) *bankTxn {
+		return &bankTxn{date: date, description: description, amount: amount, txnType: "CR", credit: credit}
+	}
+
+	testCases := []struct {
+		txn            *bankTxn
+		referenceFirst bool
+		outcome        string
+	}{
+		{newTxn("SHOP", decimal.New(-10, 0), false), false, outcomeNotCredit},
+		{newTxn("JOE BLOGGS", decimal.New(-185, -1), false), false, txnRefund},
+		{newTxn("RAFFLE", decimal.New(185, -1), true), false, txnIgnored},
+		{newTxn("JOE BLOGGS", decimal.New(45, 0), true), false, txnIgnored},
+		{newTxn("JOE BLOGGS", decimal.New(45, 0), true), true, txnWrongAmount},
+		{newTxn("NO MATCH", decimal.New(185, -1), true), false, txnUnmatched},
+		{newTxn("JOE BLOGGS", decimal.New(165, -1), true), false, txnIncorrect},
+		{newTxn("JOE BLOGGS", decimal.New(185, -1), true), true, txnMatched},
+		{newTxn("LOST MEMBER", decimal.New(185, -1), true), false, txnMatched},
+	}
+
+	for _, testCase := range testCases {
+		trace := m.explainTxn(testCase.txn, schedule, testCase.referenceFirst)
+		if trace.outcome != testCase.outcome {
+			t.Fatalf("%v (referenceFirst %v) explained as %v, expected %v: %v", testCase.txn, testCase.referenceFirst, trace.outcome, testCase.outcome, *trace)
+		}
+	}
+
+	trace := m.explainTxn(newTxn("LOST MEMBER", decimal.New(185, -1), true), schedule, false)
+	if trace.memberLookup[:4] != "fail" {
+		t.Fatalf("Expected the member lookup to fail: %v", *trace)
+	}
+}
+
+// Runs a statement through loadAndFilterTxns and checks each step-by-step
+// explanation reaches the classification the run recorded, and that rows
+// outside the month stop at the period step only when they're excluded.
+func TestExplainAgreesWithRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "explain")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	statementPath := filepath.Join(dir, DefaultBankTxnsPath)
+	err = ioutil.WriteFile(statementPath, []byte(`Date,Type,Description,Paid Out,Paid In,Balance
+31-Jan-18,CR,JOE BLOGGS, ,18.5,100.00
+01-Feb-18,CR,JOE BLOGGS, ,18.5,118.50
+02-Feb-18,CR,FAMILY, ,20,138.50
+03-Feb-18,CR,FAMILY, ,30,168.50
+04-Feb-18,CR,NO MATCH, ,18.5,187.00
+05-Feb-18,CR,RAFFLE, ,5,192.00
+`), 0644)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	refs := newReferenceMatcher()
+	refs.add(matchLiteral, "JOE BLOGGS", []string{"A1"})
+	family, _ := refs.add(matchLiteral, "FAMILY", []string{"A2", "A3"})
+	family.feeCategory = "family"
+	m := &membership{
+		references: refs,
+		members:    map[string]*Member{"A1": {MemberID: "A1"}, "A2": {MemberID: "A2"}, "A3": {MemberID: "A3"}},
+		feeSchedules: []*feeSchedule{
+			{
+				effectiveFrom:  time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC),
+				correctAmounts: []decimal.Decimal{decimal.New(185, -1)},
+				categories:     map[string]decimal.Decimal{"family": decimal.New(30, 0)},
+			},
+		},
+		ledger:     &ledger{},
+		ignoreList: &ignoreList{},
+	}
+
+	for _, excludeOutOfPeriod := range []bool{false, true} {
+		for _, referenceFirst := range []bool{false, true} {
+			rc := &runConfig{month: time.Date(2018, 2, 1, 0, 0, 0, 0, time.UTC), bankFormat: autoBankFormat, referenceFirst: referenceFirst, excludeOutOfPeriod: excludeOutOfPeriod}
+			m.ledger = &ledger{}
+			am, err := m.loadAndFilterTxns(statementPath, rc)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if len(am.txns.partial) != 1 {
+				t.Fatalf("Expected the short FAMILY payment to be partial: %v", am.txns.partial)
+			}
+
+			for _, txn := range am.txns.all {
+				entry, ok := am.txns.classified[txn]
+				if !ok {
+					continue
+				}
+
+				trace := m.explainTxn(txn, am.feeSchedule, referenceFirst)
+				if entry.classification != trace.outcome {
+					t.Fatalf("%v (referenceFirst %v) explained as %v but recorded as %v", txn, referenceFirst, trace.outcome, entry.classification)
+				}
+			}
+
+			traces := m.explainTxns(am.txns.all, am, rc)
+			if len(traces) != len(am.txns.all) {
+				t.Fatalf("%v != %v", len(traces), len(am.txns.all))
+			}
+
+			expected := txnMatched
+			if excludeOutOfPeriod {
+				expected = outcomeOutOfPeriod
+			}
+
+			if traces[0].outcome != expected {
+				t.Fatalf("%v (excludeOutOfPeriod %v) explained as %v, expected %v: %v", traces[0].txn, excludeOutOfPeriod, traces[0].outcome, expected, *traces[0])
+			}
+
+			for _, trace := range traces[1:] {
+				if am.txns.classified[trace.txn].classification != trace.outcome {
+					t.Fatalf("%v != %v", trace.outcome, am.txns.classified[trace.txn].classification)
+				}
+			}
+		}
+	}
+}
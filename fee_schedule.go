@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const feeScheduleDateFormat = "2006-01-02"
+
+type feeSchedule struct {
+	effectiveFrom  time.Time
+	effectiveUntil time.Time
+	correctAmounts []decimal.Decimal
+	legacyAmounts  []decimal.Decimal
+	annualAmounts  []decimal.Decimal
+	categories     map[string]decimal.Decimal
+}
+
+func newFeeSchedule(yamlSchedule *YamlFeeSchedule) (*feeSchedule, error) {
+	var err error
+	fs := feeSchedule{}
+	fs.effectiveFrom, err = time.Parse(feeScheduleDateFormat, yamlSchedule.EffectiveFrom)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(yamlSchedule.EffectiveUntil) > 0 {
+		fs.effectiveUntil, err = time.Parse(feeScheduleDateFormat, yamlSchedule.EffectiveUntil)
+		if err != nil {
+			return nil, err
+		}
+
+		if fs.effectiveUntil.Before(fs.effectiveFrom) {
+			return nil, fmt.Errorf("effectiveUntil %v is before effectiveFrom %v", yamlSchedule.EffectiveUntil, yamlSchedule.EffectiveFrom)
+		}
+	}
+
+	fs.correctAmounts, err = parseAmounts(yamlSchedule.CorrectAmounts)
+	if err != nil {
+		return nil, err
+	}
+
+	fs.legacyAmounts, err = parseAmounts(yamlSchedule.LegacyAmounts)
+	if err != nil {
+		return nil, err
+	}
+
+	fs.annualAmounts, err = parseAmounts(yamlSchedule.AnnualAmounts)
+	if err != nil {
+		return nil, err
+	}
+
+	fs.categories = map[string]decimal.Decimal{}
+	for category, amount := range yamlSchedule.Categories {
+		amt, err := decimal.NewFromString(amount)
+		if err != nil {
+			return nil, err
+		}
+
+		fs.categories[strings.ToLower(strings.TrimSpace(category))] = amt
+	}
+
+	return &fs, nil
+}
+
+func parseAmounts(amounts []string) (parsed []decimal.Decimal, err error) {
+	for _, amount := range amounts {
+		amt, err := decimal.NewFromString(amount)
+		if err != nil {
+			return nil, err
+		}
+
+		parsed = append(parsed, amt)
+	}
+
+	return parsed, nil
+}
+
+// Annual payments are correct payments in their own right, they just cover
+// more than one month.
+func (fs *feeSchedule) allCorrectAmounts() []decimal.Decimal {
+	amounts := append([]decimal.Decimal{}, fs.correctAmounts...)
+	return append(amounts, fs.annualAmounts...)
+}
+
+func (fs *feeSchedule) allAmounts() []decimal.Decimal {
+	amounts := append(fs.allCorrectAmounts(), fs.legacyAmounts...)
+	for _, amount := range fs.categories {
+		amounts = append(amounts, amount)
+	}
+
+	return amounts
+}
+
+func containsAmount(amounts []decimal.Decimal, amount decimal.Decimal) bool {
+	for _, candidate := range amounts {
+		if candidate.Equal(amount) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+A payment mapped to a fee category has to be that category's amount (or an
+annual amount), and the expected amount is returned so the difference can be
+reported. Anything else just has to be one of the correct amounts.
+*/
+func (fs *feeSchedule) checkAmount(amount decimal.Decimal, rule *referenceRule) (correct bool, expected *decimal.Decimal, err error) {
+	if rule == nil || len(rule.feeCategory) == 0 {
+		return containsAmount(fs.allCorrectAmounts(), amount), nil, nil
+	}
+
+	categoryAmount, ok := fs.categories[rule.feeCategory]
+	if !ok {
+		return false, nil, fmt.Errorf("Reference %v has fee category %v, which isn't in the fee schedule effective from %v", rule, rule.feeCategory, fs.effectiveFrom.Format(feeScheduleDateFormat))
+	}
+
+	return categoryAmount.Equal(amount) || containsAmount(fs.annualAmounts, amount), &categoryAmount, nil
+}
+
+/*
+The monthly fee expected from one member: their reference's fee category, or
+otherwise whichever of the correct amounts they pay (paying, when it's one of
+them) and failing that the first, split between everyone the reference pays
+for. Any correct amount is accepted, so it's also what's expected.
+*/
+func (fs *feeSchedule) memberFee(rule *referenceRule, paying decimal.Decimal) (decimal.Decimal, error) {
+	if len(fs.correctAmounts) == 0 {
+		return decimal.Zero, fmt.Errorf("Fee schedule effective from %v has no correct amounts", fs.effectiveFrom.Format(feeScheduleDateFormat))
+	}
+
+	fee := fs.correctAmounts[0]
+	if containsAmount(fs.correctAmounts, paying) {
+		fee = paying
+	}
+	members := 1
+	if rule != nil {
+		if len(rule.memberIDs) > 1 {
+			members = len(rule.memberIDs)
+		}
+
+		if len(rule.feeCategory) > 0 {
+			categoryAmount, ok := fs.categories[rule.feeCategory]
+			if !ok {
+				return decimal.Zero, fmt.Errorf("Reference %v has fee category %v, which isn't in the fee schedule effective from %v", rule, rule.feeCategory, fs.effectiveFrom.Format(feeScheduleDateFormat))
+			}
+
+			fee = categoryAmount
+		}
+	}
+
+	return fee.Div(decimal.New(int64(members), 0)).Round(2), nil
+}
+
+// Incorrect payments with the amount they should have been: their fee
+// category's, or otherwise the standard fee.
+func identifyIncorrectTxns(txns []*bankTxn, references *referenceMatcher, schedule *feeSchedule) (incorrectTxns []*bankTxn, expected map[*bankTxn]decimal.Decimal, err error) {
+	expected = make(map[*bankTxn]decimal.Decimal)
+	for _, txn := range txns {
+		rule, _ := references.matchTxn(txn)
+		correct, expectedAmount, err := schedule.checkAmount(txn.amount, rule)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if !correct {
+			incorrectTxns = append(incorrectTxns, txn)
+			if expectedAmount != nil {
+				expected[txn] = *expectedAmount
+			} else if len(schedule.correctAmounts) > 0 {
+				expected[txn] = schedule.correctAmounts[0]
+			}
+		}
+	}
+
+	return incorrectTxns, expected, nil
+}
+
+func (fs *feeSchedule) coverageMonths(amount decimal.Decimal) int {
+	for _, annualAmount := range fs.annualAmounts {
+		if annualAmount.Equal(amount) {
+			return 12
+		}
+	}
+
+	return 1
+}
+
+func (fs *feeSchedule) effectiveOn(date time.Time) bool {
+	if date.Before(fs.effectiveFrom) {
+		return false
+	}
+
+	return fs.effectiveUntil.IsZero() || !date.After(fs.effectiveUntil)
+}
+
+// The schedule in force on the first day of the month wins. If several
+// overlap, the one that took effect most recently is used.
+func selectFeeSchedule(schedules []*feeSchedule, month time.Time) (*feeSchedule, error) {
+	monthStart := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.UTC)
+	var selected *feeSchedule
+	for _, schedule := range schedules {
+		if schedule.effectiveOn(monthStart) {
+			if selected == nil || schedule.effectiveFrom.After(selected.effectiveFrom) {
+				selected = schedule
+			}
+		}
+	}
+
+	if selected == nil {
+		return nil, fmt.Errorf("No fee schedule in effect for %v", monthStart.Format("200601"))
+	}
+
+	return selected, nil
+}
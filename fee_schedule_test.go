@@ -0,0 +1,114 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestSelectFeeSchedule(t *testing.T) {
+	schedules := []*feeSchedule{
+		{
+			effectiveFrom:  time.Date(2017, 4, 1, 0, 0, 0, 0, time.UTC),
+			effectiveUntil: time.Date(2018, 3, 31, 0, 0, 0, 0, time.UTC),
+			correctAmounts: []decimal.Decimal{decimal.New(165, -1)},
+			legacyAmounts:  []decimal.Decimal{decimal.New(15, 0)},
+		},
+		{
+			effectiveFrom:  time.Date(2018, 4, 1, 0, 0, 0, 0, time.UTC),
+			correctAmounts: []decimal.Decimal{decimal.New(185, -1)},
+			legacyAmounts:  []decimal.Decimal{decimal.New(165, -1)},
+		},
+	}
+
+	testCases := []struct {
+		month    time.Time
+		expected *feeSchedule
+	}{
+		{time.Date(2017, 4, 1, 0, 0, 0, 0, time.UTC), schedules[0]},
+		{time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC), schedules[0]},
+		{time.Date(2018, 4, 1, 0, 0, 0, 0, time.UTC), schedules[1]},
+		{time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), schedules[1]},
+	}
+
+	for _, testCase := range testCases {
+		actual, err := selectFeeSchedule(schedules, testCase.month)
+		if err != nil {
+			t.Fatalf("Unexpected error for %v: %v", testCase.month, err)
+		}
+
+		if actual != testCase.expected {
+			t.Fatalf("Wrong schedule for %v (%v, %v)", testCase.month, actual, testCase.expected)
+		}
+	}
+
+	_, err := selectFeeSchedule(schedules, time.Date(2017, 3, 1, 0, 0, 0, 0, time.UTC))
+	if err == nil {
+		t.Fatalf("Expected an error for a month with no fee schedule")
+	}
+}
+
+func TestCoverageMonths(t *testing.T) {
+	schedule := &feeSchedule{
+		correctAmounts: []decimal.Decimal{decimal.New(185, -1)},
+		annualAmounts:  []decimal.Decimal{decimal.New(200, 0)},
+	}
+
+	if months := schedule.coverageMonths(decimal.New(185, -1)); months != 1 {
+		t.Fatalf("Monthly amount covers %v months", months)
+	}
+
+	if months := schedule.coverageMonths(decimal.New(200, 0)); months != 12 {
+		t.Fatalf("Annual amount covers %v months", months)
+	}
+}
+
+func TestIdentifyIncorrectTxns(t *testing.T) {
+	schedule := &feeSchedule{
+		correctAmounts: []decimal.Decimal{decimal.New(185, -1)},
+		annualAmounts:  []decimal.Decimal{decimal.New(200, 0)},
+		categories:     map[string]decimal.Decimal{"concession": decimal.New(10, 0)},
+	}
+
+	refs := newReferenceMatcher()
+	rule, err := refs.add(matchLiteral, "CONCESSION", []string{"A1"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	rule.feeCategory = "concession"
+	_, err = refs.add(matchLiteral, "STANDARD", []string{"A2"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	concessionRight := &bankTxn{description: "CONCESSION", amount: decimal.New(10, 0)}
+	concessionWrong := &bankTxn{description: "CONCESSION", amount: decimal.New(185, -1)}
+	concessionAnnual := &bankTxn{description: "CONCESSION", amount: decimal.New(200, 0)}
+	standardRight := &bankTxn{description: "STANDARD", amount: decimal.New(185, -1)}
+	standardWrong := &bankTxn{description: "STANDARD", amount: decimal.New(10, 0)}
+
+	incorrect, expected, err := identifyIncorrectTxns([]*bankTxn{concessionRight, concessionWrong, concessionAnnual, standardRight, standardWrong}, refs, schedule)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(incorrect) != 2 || incorrect[0] != concessionWrong || incorrect[1] != standardWrong {
+		t.Fatalf("%v != %v", incorrect, []*bankTxn{concessionWrong, standardWrong})
+	}
+
+	if amount, ok := expected[concessionWrong]; !ok || !amount.Equal(decimal.New(10, 0)) {
+		t.Fatalf("%v != %v", amount, decimal.New(10, 0))
+	}
+
+	if amount, ok := expected[standardWrong]; !ok || !amount.Equal(decimal.New(185, -1)) {
+		t.Fatalf("%v != %v", amount, decimal.New(185, -1))
+	}
+
+	rule.feeCategory = "student"
+	_, _, err = identifyIncorrectTxns([]*bankTxn{concessionRight}, refs, schedule)
+	if err == nil {
+		t.Fatalf("Expected an error for an unknown fee category")
+	}
+}
@@ -2,15 +2,20 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/csv"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/gocarina/gocsv"
 	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"gopkg.in/yaml.v2"
 )
 
 type fileConfig struct {
@@ -27,6 +32,27 @@ func (fc *fileConfig) getCurrentSourcePath(fileName string) string {
 	return filepath.Join(fc.baseDir, "in", fc.currentFolderName, fileName)
 }
 
+func (fc *fileConfig) getLedgerPath() string {
+	return filepath.Join(fc.baseDir, "ledger.csv")
+}
+
+// Returns the first of the candidate files that exists, or the first
+// candidate if none do.
+func (fc *fileConfig) findCurrentSourcePath(fileNames ...string) string {
+	for _, fileName := range fileNames {
+		path := fc.getCurrentSourcePath(fileName)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+
+	return fc.getCurrentSourcePath(fileNames[0])
+}
+
+func (fc *fileConfig) getBankTxnsPath() string {
+	return fc.findCurrentSourcePath(DefaultBankTxnsPath, DefaultOfxBankTxnsPath, DefaultQfxBankTxnsPath)
+}
+
 func (fc *fileConfig) getCurrentDestinationPath(fileName string) string {
 	return filepath.Join(fc.baseDir, "out", fc.currentFolderName, fileName)
 }
@@ -71,48 +97,217 @@ func loadAllMembersFromCsv(path string) (members []*Member, err error) {
 	return members, nil
 }
 
-type CsvBankTxn struct {
-	Junk1       string `csv:"Date"`
-	TxnType     string `csv:"Type"`
-	Description string `csv:"Description"`
-	Junk2       string `csv:"Paid Out"`
-	Amount      string `csv:"Paid In"`
-	Junk3       string `csv:"Balance"`
+type YamlFeeSchedule struct {
+	EffectiveFrom  string            `yaml:"effectiveFrom"`
+	EffectiveUntil string            `yaml:"effectiveUntil"`
+	CorrectAmounts []string          `yaml:"correctAmounts"`
+	LegacyAmounts  []string          `yaml:"legacyAmounts"`
+	AnnualAmounts  []string          `yaml:"annualAmounts"`
+	Categories     map[string]string `yaml:"categories"`
 }
 
-func loadTxnsFromCsv(path string) (txns []*bankTxn, err error) {
-	txnFile, err := os.Open(path)
+type YamlFeeSchedules struct {
+	Schedules []*YamlFeeSchedule `yaml:"schedules"`
+}
+
+func loadFeeSchedulesFromYaml(path string) (schedules []*feeSchedule, err error) {
+	content, err := ioutil.ReadFile(path)
 	if err != nil {
 		return nil, errors.Wrapf(err, "Failed to open %s", path)
 	}
-	defer txnFile.Close()
 
-	loadedTxns := []*CsvBankTxn{}
-	err = gocsv.UnmarshalFile(txnFile, &loadedTxns)
+	loadedSchedules := YamlFeeSchedules{}
+	err = yaml.UnmarshalStrict(content, &loadedSchedules)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to parse fee schedules from %s", path)
+	}
+
+	for _, loadedSchedule := range loadedSchedules.Schedules {
+		schedule, err := newFeeSchedule(loadedSchedule)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to parse fee schedule (%v) from %s", *loadedSchedule, path)
+		}
+
+		schedules = append(schedules, schedule)
+	}
+
+	return schedules, nil
+}
+
+type YamlBankCsvMapping struct {
+	DateColumn        string   `yaml:"dateColumn"`
+	DescriptionColumn string   `yaml:"descriptionColumn"`
+	CreditColumn      string   `yaml:"creditColumn"`
+	DebitColumn       string   `yaml:"debitColumn"`
+	AmountColumn      string   `yaml:"amountColumn"`
+	TypeColumn        string   `yaml:"typeColumn"`
+	BalanceColumn     string   `yaml:"balanceColumn"`
+	CreditTypes       []string `yaml:"creditTypes"`
+	DateFormat        string   `yaml:"dateFormat"`
+	DecimalSeparator  string   `yaml:"decimalSeparator"`
+	SkipRows          int      `yaml:"skipRows"`
+}
+
+type YamlBankFormats struct {
+	CsvMappings map[string]*YamlBankCsvMapping `yaml:"csvMappings"`
+	TxnTypes    map[string]string              `yaml:"txnTypes"`
+}
+
+// The bank formats file is optional.
+func loadBankFormatsFromYaml(path string) (formats *YamlBankFormats, err error) {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &YamlBankFormats{}, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "Failed to open %s", path)
+	}
+
+	loadedFormats := YamlBankFormats{}
+	err = yaml.UnmarshalStrict(content, &loadedFormats)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to parse bank formats from %s", path)
+	}
+
+	return &loadedFormats, nil
+}
+
+func loadTxnsFromFile(path string, bankFormat string) (txns []*bankTxn, err error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to open %s", path)
+	}
+
+	importer, err := selectBankTxnImporter(bankFormat, content)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to import transactions from %s", path)
+	}
+
+	txns, err = importer.importTxns(bytes.NewReader(content))
 	if err != nil {
 		return nil, errors.Wrapf(err, "Failed to parse transactions from %s", path)
 	}
 
-	for _, loadedTxn := range loadedTxns {
-		if loadedTxn.TxnType == "CR" {
-			txn, err := newBankTxn(loadedTxn.Description, loadedTxn.Amount)
-			if err != nil {
-				return nil, fmt.Errorf("Failed to parse transaction (%v): %v", *loadedTxn, err)
-			}
+	return txns, nil
+}
+
+const ledgerDateFormat = "2006-01-02"
+
+type CsvLedgerEntry struct {
+	Date           string `csv:"Date"`
+	Type           string `csv:"Type"`
+	Description    string `csv:"Description"`
+	Amount         string `csv:"Amount"`
+	Classification string `csv:"Classification"`
+	MemberIDs      string `csv:"MemberIds"`
+	MatchedRule    string `csv:"MatchedRule"`
+	RecordedIn     string `csv:"RecordedIn"`
+	FitID          string `csv:"FitId"`
+}
 
-			txns = append(txns, txn)
+func loadLedgerFromCsv(path string) (l *ledger, err error) {
+	l = &ledger{}
+	ledgerFile, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return l, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "Failed to open %s", path)
+	}
+	defer ledgerFile.Close()
+
+	loadedEntries := []*CsvLedgerEntry{}
+	err = gocsv.UnmarshalFile(ledgerFile, &loadedEntries)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to parse ledger from %s", path)
+	}
+
+	for _, loadedEntry := range loadedEntries {
+		date, err := time.Parse(ledgerDateFormat, loadedEntry.Date)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse ledger entry date (%v): %v", *loadedEntry, err)
+		}
+
+		txn, err := newBankTxn(date, loadedEntry.Description, loadedEntry.Amount)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse ledger entry (%v): %v", *loadedEntry, err)
 		}
+
+		txn.fitID = loadedEntry.FitID
+		txn.txnType = loadedEntry.Type
+
+		var memberIDs []string
+		if len(loadedEntry.MemberIDs) > 0 {
+			memberIDs = strings.Split(loadedEntry.MemberIDs, "|")
+		}
+
+		l.entries = append(l.entries, &ledgerEntry{txn, loadedEntry.Classification, memberIDs, loadedEntry.MatchedRule, loadedEntry.RecordedIn})
 	}
 
-	return txns, nil
+	return l, nil
+}
+
+func writeLedgerToCsv(path string, l *ledger) error {
+	csvEntries := []*CsvLedgerEntry{}
+	for _, entry := range l.entries {
+		csvEntries = append(csvEntries, &CsvLedgerEntry{
+			entry.txn.date.Format(ledgerDateFormat),
+			entry.txn.txnType,
+			entry.txn.description,
+			entry.txn.amount.String(),
+			entry.classification,
+			strings.Join(entry.memberIDs, "|"),
+			entry.matchedRule,
+			entry.recordedIn,
+			entry.txn.fitID,
+		})
+	}
+
+	// Write alongside and rename so a failed run can't truncate the ledger.
+	tmpPath := path + ".tmp"
+	ledgerFile, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	err = gocsv.MarshalFile(&csvEntries, ledgerFile)
+	if err != nil {
+		ledgerFile.Close()
+		return err
+	}
+
+	err = ledgerFile.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
 }
 
 type MemberReference struct {
-	Reference string `csv:"Reference"`
-	MemberIDs string `csv:"MemberIds"`
+	Reference   string `csv:"Reference"`
+	MemberIDs   string `csv:"MemberIds"`
+	Match       string `csv:"Match"`
+	FeeCategory string `csv:"FeeCategory"`
+	ValidFrom   string `csv:"ValidFrom"`
+	ValidTo     string `csv:"ValidTo"`
 }
 
-func loadMemberReferencesFromCsv(path string) (references map[string][]string, err error) {
+// Blank dates leave the mapping open ended.
+func parseValidity(validFrom, validTo string) (from, to time.Time, err error) {
+	if len(strings.TrimSpace(validFrom)) > 0 {
+		from, err = time.Parse(ledgerDateFormat, strings.TrimSpace(validFrom))
+		if err != nil {
+			return from, to, err
+		}
+	}
+
+	if len(strings.TrimSpace(validTo)) > 0 {
+		to, err = time.Parse(ledgerDateFormat, strings.TrimSpace(validTo))
+	}
+
+	return from, to, err
+}
+
+func loadMemberReferencesFromCsv(path string) (references *referenceMatcher, err error) {
 	referenceFile, err := os.Open(path)
 	if err != nil {
 		return nil, errors.Wrapf(err, "Failed to open %s", path)
@@ -125,21 +320,211 @@ func loadMemberReferencesFromCsv(path string) (references map[string][]string, e
 		return nil, errors.Wrapf(err, "Failed to parse membership from %s", path)
 	}
 
-	references = map[string][]string{}
+	references = newReferenceMatcher()
 	for _, loadedReference := range loadedReferences {
-		reference := strings.TrimSpace(strings.ToUpper(loadedReference.Reference))
-		membershipIds := strings.Split(loadedReference.MemberIDs, "|")
-		_, ok := references[reference]
-		if !ok {
-			references[reference] = membershipIds
+		membershipIds := parseMemberIDs(loadedReference.MemberIDs)
+		kind := strings.ToLower(strings.TrimSpace(loadedReference.Match))
+		validFrom, validTo, err := parseValidity(loadedReference.ValidFrom, loadedReference.ValidTo)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to parse reference dates (%v) from %s", *loadedReference, path)
+		}
+
+		rule, err := references.addValid(kind, loadedReference.Reference, membershipIds, validFrom, validTo)
+		if err == errDuplicateReference {
+			fmt.Fprintf(os.Stderr, "Loaded duplicate reference %v\n", loadedReference.Reference)
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "Failed to parse reference (%v) from %s", *loadedReference, path)
 		} else {
-			fmt.Fprintf(os.Stderr, "Loaded duplicate reference %v\n", reference)
+			rule.feeCategory = strings.ToLower(strings.TrimSpace(loadedReference.FeeCategory))
 		}
 	}
 
 	return references, nil
 }
 
+// The rows as they are in the file, for editing rather than matching.
+func loadMemberReferenceRowsFromCsv(path string) (rows []*MemberReference, err error) {
+	referenceFile, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to open %s", path)
+	}
+	defer referenceFile.Close()
+
+	err = gocsv.UnmarshalFile(referenceFile, &rows)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to parse membership from %s", path)
+	}
+
+	return rows, nil
+}
+
+func writeMemberReferencesToCsv(w io.Writer, rows []*MemberReference) error {
+	return gocsv.Marshal(&rows, w)
+}
+
+func writeMemberReferencesToCsvFile(path string, rows []*MemberReference) error {
+	referenceFile, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer referenceFile.Close()
+
+	return gocsv.MarshalFile(&rows, referenceFile)
+}
+
+// Rewrites the mapping file with the new references added at the end.
+func addMemberReferencesToCsv(path string, newReferences []*MemberReference) error {
+	loadedReferences, err := loadMemberReferenceRowsFromCsv(path)
+	if err != nil {
+		return err
+	}
+
+	return writeMemberReferencesToCsvFile(path, append(loadedReferences, newReferences...))
+}
+
+type CsvIgnoreRule struct {
+	Reference string `csv:"Reference"`
+	Date      string `csv:"Date"`
+	Amount    string `csv:"Amount"`
+	Reason    string `csv:"Reason"`
+}
+
+// The ignore list is optional.
+func loadIgnoreListFromCsv(path string) (il *ignoreList, err error) {
+	il = &ignoreList{}
+	ignoreFile, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return il, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "Failed to open %s", path)
+	}
+	defer ignoreFile.Close()
+
+	loadedRules := []*CsvIgnoreRule{}
+	err = gocsv.UnmarshalFile(ignoreFile, &loadedRules)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to parse ignore list from %s", path)
+	}
+
+	for _, loadedRule := range loadedRules {
+		rule := &ignoreRule{reference: normaliseReference(loadedRule.Reference), reason: loadedRule.Reason}
+		if len(loadedRule.Date) > 0 {
+			date, err := time.Parse(ledgerDateFormat, loadedRule.Date)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to parse ignore rule date (%v): %v", *loadedRule, err)
+			}
+
+			rule.txn, err = newBankTxn(date, loadedRule.Reference, loadedRule.Amount)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to parse ignore rule (%v): %v", *loadedRule, err)
+			}
+		}
+
+		il.rules = append(il.rules, rule)
+	}
+
+	return il, nil
+}
+
+func writeIgnoreListToCsv(path string, il *ignoreList) error {
+	csvRules := []*CsvIgnoreRule{}
+	for _, rule := range il.rules {
+		csvRule := &CsvIgnoreRule{Reference: rule.reference, Reason: rule.reason}
+		if rule.txn != nil {
+			csvRule.Date = rule.txn.date.Format(ledgerDateFormat)
+			csvRule.Amount = rule.txn.amount.String()
+		}
+
+		csvRules = append(csvRules, csvRule)
+	}
+
+	ignoreFile, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer ignoreFile.Close()
+
+	return gocsv.MarshalFile(&csvRules, ignoreFile)
+}
+
+type CsvTxnDecision struct {
+	Date        string `csv:"Date"`
+	Type        string `csv:"Type"`
+	Description string `csv:"Description"`
+	Amount      string `csv:"Amount"`
+	Reference   string `csv:"Reference"`
+	Suggestion  string `csv:"Suggestion"`
+	MemberIDs   string `csv:"MemberIds"`
+	Decision    string `csv:"Decision"`
+}
+
+func writeDecisionsToCsv(path string, txns []*bankTxn, suggestions map[*bankTxn][]*referenceSuggestion) error {
+	csvDecisions := []*CsvTxnDecision{}
+	for _, txn := range txns {
+		csvDecision := &CsvTxnDecision{
+			Date:        txn.date.Format(ledgerDateFormat),
+			Type:        txn.txnType,
+			Description: txn.description,
+			Amount:      txn.amount.String(),
+			Reference:   txn.reference(),
+		}
+		if len(suggestions[txn]) > 0 {
+			csvDecision.Suggestion = suggestions[txn][0].reference
+		}
+
+		csvDecisions = append(csvDecisions, csvDecision)
+	}
+
+	decisionsFile, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer decisionsFile.Close()
+
+	return gocsv.MarshalFile(&csvDecisions, decisionsFile)
+}
+
+func loadDecisionsFromCsv(path string) (decisions []*txnDecision, err error) {
+	decisionsFile, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to open %s", path)
+	}
+	defer decisionsFile.Close()
+
+	loadedDecisions := []*CsvTxnDecision{}
+	err = gocsv.UnmarshalFile(decisionsFile, &loadedDecisions)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to parse decisions from %s", path)
+	}
+
+	for _, loadedDecision := range loadedDecisions {
+		date, err := time.Parse(ledgerDateFormat, loadedDecision.Date)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse decision date (%v): %v", *loadedDecision, err)
+		}
+
+		txn, err := newBankTxn(date, loadedDecision.Description, loadedDecision.Amount)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse decision (%v): %v", *loadedDecision, err)
+		}
+
+		txn.txnType = loadedDecision.Type
+
+		var memberIDs []string
+		for _, memberID := range strings.Split(loadedDecision.MemberIDs, "|") {
+			memberID = strings.ToUpper(strings.TrimSpace(memberID))
+			if len(memberID) > 0 {
+				memberIDs = append(memberIDs, memberID)
+			}
+		}
+
+		decision := strings.ToLower(strings.TrimSpace(loadedDecision.Decision))
+		decisions = append(decisions, &txnDecision{txn, memberIDs, decision})
+	}
+
+	return decisions, nil
+}
+
 func loadEmailsFromCsv(path string) (emails []string, err error) {
 	emailsFile, err := os.Open(path)
 	if err != nil {
@@ -163,21 +548,64 @@ func loadEmailsFromCsv(path string) (emails []string, err error) {
 	return emails, nil
 }
 
-func writeTxnsToCsv(path string, txns []*bankTxn) error {
+// Every column the bank gave us for the transactions, in the order first seen.
+func originalColumnNames(txns []*bankTxn) (names []string) {
+	seen := map[string]bool{}
+	for _, txn := range txns {
+		for _, column := range txn.originalColumns() {
+			if !seen[column.name] {
+				seen[column.name] = true
+				names = append(names, column.name)
+			}
+		}
+	}
+
+	return names
+}
+
+/*
+Writes each transaction's original statement columns followed by what we
+worked out about it: the normalised reference, its classification and the
+member IDs it matched. extraColumns adds any columns specific to the report.
+*/
+func writeTxnDetailsToCsv(path string, txns []*bankTxn, classified map[*bankTxn]*ledgerEntry, extraHeader []string, extraColumns func(*bankTxn) []string) error {
 	txnFile, err := os.Create(path)
 	if err != nil {
 		return err
 	}
 	defer txnFile.Close()
 
+	names := originalColumnNames(txns)
+	header := append(append([]string{}, names...), "Reference", "Classification", "MemberIds")
 	csvWriter := csv.NewWriter(bufio.NewWriter(txnFile))
-	err = csvWriter.Write([]string{"Description", "Amount"})
+	err = csvWriter.Write(append(header, extraHeader...))
 	if err != nil {
 		return err
 	}
 
 	for _, txn := range txns {
-		err := csvWriter.Write([]string{txn.description, txn.amount.String()})
+		values := map[string]string{}
+		for _, column := range txn.originalColumns() {
+			values[column.name] = column.value
+		}
+
+		line := []string{}
+		for _, name := range names {
+			line = append(line, values[name])
+		}
+
+		classification, memberIDs := "", ""
+		if entry, ok := classified[txn]; ok {
+			classification = entry.classification
+			memberIDs = strings.Join(entry.memberIDs, "|")
+		}
+
+		line = append(line, txn.reference(), classification, memberIDs)
+		if extraColumns != nil {
+			line = append(line, extraColumns(txn)...)
+		}
+
+		err := csvWriter.Write(line)
 		if err != nil {
 			return err
 		}
@@ -185,7 +613,222 @@ func writeTxnsToCsv(path string, txns []*bankTxn) error {
 
 	csvWriter.Flush()
 
-	return nil
+	return csvWriter.Error()
+}
+
+func writeTxnsToCsv(path string, txns []*bankTxn, classified map[*bankTxn]*ledgerEntry) error {
+	return writeTxnDetailsToCsv(path, txns, classified, nil, nil)
+}
+
+func writeIncorrectTxnsToCsv(path string, txns []*bankTxn, classified map[*bankTxn]*ledgerEntry, expected map[*bankTxn]decimal.Decimal) error {
+	return writeTxnDetailsToCsv(path, txns, classified, []string{"Expected", "Difference"}, func(txn *bankTxn) []string {
+		expectedAmount, ok := expected[txn]
+		if !ok {
+			return []string{"", ""}
+		}
+
+		return []string{expectedAmount.String(), txn.amount.Sub(expectedAmount).String()}
+	})
+}
+
+type CsvAllocation struct {
+	Date        string `csv:"Date"`
+	Type        string `csv:"Type"`
+	Description string `csv:"Description"`
+	Amount      string `csv:"Amount"`
+	Expected    string `csv:"Expected"`
+	MemberID    string `csv:"MemberId"`
+	MemberFee   string `csv:"MemberFee"`
+	Allocated   string `csv:"Allocated"`
+	Covered     bool   `csv:"Covered"`
+}
+
+func writePartialPaymentsToCsv(path string, payments []*partialPayment) error {
+	csvAllocations := []*CsvAllocation{}
+	for _, payment := range payments {
+		for _, allocation := range payment.allocations {
+			csvAllocations = append(csvAllocations, &CsvAllocation{
+				payment.txn.date.Format(ledgerDateFormat),
+				payment.txn.txnType,
+				payment.txn.description,
+				payment.txn.amount.String(),
+				payment.expected.String(),
+				allocation.memberID,
+				allocation.expected.String(),
+				allocation.allocated.String(),
+				allocation.covered(),
+			})
+		}
+	}
+
+	paymentsFile, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer paymentsFile.Close()
+
+	return gocsv.MarshalFile(&csvAllocations, paymentsFile)
+}
+
+type CsvDuplicatePayment struct {
+	MemberID    string `csv:"MemberId"`
+	Date        string `csv:"Date"`
+	Type        string `csv:"Type"`
+	Description string `csv:"Description"`
+	Reference   string `csv:"Reference"`
+	Amount      string `csv:"Amount"`
+	MatchedRule string `csv:"MatchedRule"`
+	RuleMembers string `csv:"RuleMemberIds"`
+}
+
+func writeDuplicatePaymentsToCsv(path string, duplicates []*duplicatePayment) error {
+	csvDuplicates := []*CsvDuplicatePayment{}
+	for _, duplicate := range duplicates {
+		for i, txn := range duplicate.txns {
+			csvDuplicates = append(csvDuplicates, &CsvDuplicatePayment{
+				duplicate.memberID,
+				txn.date.Format(ledgerDateFormat),
+				txn.txnType,
+				txn.description,
+				txn.reference(),
+				txn.amount.String(),
+				duplicate.rules[i].String(),
+				strings.Join(duplicate.rules[i].memberIDs, "|"),
+			})
+		}
+	}
+
+	duplicatesFile, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer duplicatesFile.Close()
+
+	return gocsv.MarshalFile(&csvDuplicates, duplicatesFile)
+}
+
+type CsvSuggestedReference struct {
+	Reference   string `csv:"Reference"`
+	MemberIDs   string `csv:"MemberIds"`
+	Confidence  string `csv:"Confidence"`
+	Forenames   string `csv:"Forenames"`
+	Surname     string `csv:"Surname"`
+	Date        string `csv:"Date"`
+	Description string `csv:"Description"`
+	Amount      string `csv:"Amount"`
+}
+
+func writeSuggestedReferencesToCsv(path string, suggestions []*nameSuggestion) error {
+	csvSuggestions := []*CsvSuggestedReference{}
+	for _, suggestion := range suggestions {
+		csvSuggestions = append(csvSuggestions, &CsvSuggestedReference{
+			suggestion.reference,
+			suggestion.member.MemberID,
+			fmt.Sprintf("%.2f", suggestion.confidence),
+			suggestion.member.Forenames,
+			suggestion.member.Surname,
+			suggestion.txn.date.Format(ledgerDateFormat),
+			suggestion.txn.description,
+			suggestion.txn.amount.String(),
+		})
+	}
+
+	suggestionsFile, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer suggestionsFile.Close()
+
+	return gocsv.MarshalFile(&csvSuggestions, suggestionsFile)
+}
+
+func writeUnmatchedTxnsToCsv(path string, txns []*bankTxn, classified map[*bankTxn]*ledgerEntry, suggestions map[*bankTxn][]*referenceSuggestion) error {
+	header := []string{}
+	for i := 1; i <= maxReferenceSuggestions; i++ {
+		header = append(header, fmt.Sprintf("Candidate%v", i), fmt.Sprintf("Score%v", i))
+	}
+
+	return writeTxnDetailsToCsv(path, txns, classified, header, func(txn *bankTxn) (line []string) {
+		for _, suggestion := range suggestions[txn] {
+			line = append(line, suggestion.reference, fmt.Sprintf("%.2f", suggestion.score))
+		}
+
+		return line
+	})
+}
+
+func writeTracesToCsv(w io.Writer, traces []*txnTrace) error {
+	csvWriter := csv.NewWriter(w)
+	err := csvWriter.Write([]string{"Date", "Type", "Description", "Amount", "Reference", "Period", "TypeFilter", "IgnoreList", "AmountFilter", "CorrectAmount", "ReferenceLookup", "MemberLookup", "Outcome"})
+	if err != nil {
+		return err
+	}
+
+	for _, trace := range traces {
+		err := csvWriter.Write([]string{
+			trace.txn.date.Format(ledgerDateFormat),
+			trace.txn.txnType,
+			trace.txn.description,
+			trace.txn.amount.String(),
+			trace.txn.reference(),
+			trace.period,
+			trace.typeFilter,
+			trace.ignoreList,
+			trace.amountFilter,
+			trace.correctAmount,
+			trace.referenceLookup,
+			trace.memberLookup,
+			trace.outcome,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	csvWriter.Flush()
+
+	return csvWriter.Error()
+}
+
+func writeReferenceProblemsToCsv(w io.Writer, problems []*referenceProblem) error {
+	csvWriter := csv.NewWriter(w)
+	err := csvWriter.Write([]string{"Problem", "Reference", "MemberIds", "MemberId", "Detail"})
+	if err != nil {
+		return err
+	}
+
+	for _, problem := range problems {
+		err := csvWriter.Write([]string{
+			problem.problem,
+			problem.rule.String(),
+			strings.Join(problem.rule.memberIDs, "|"),
+			problem.memberID,
+			problem.detail,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	csvWriter.Flush()
+
+	return csvWriter.Error()
+}
+
+func writeTracesToCsvFile(path string, traces []*txnTrace) error {
+	traceFile, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer traceFile.Close()
+
+	traceWriter := bufio.NewWriter(traceFile)
+	err = writeTracesToCsv(traceWriter, traces)
+	if err != nil {
+		return err
+	}
+
+	return traceWriter.Flush()
 }
 
 func writeMembersToCsv(path string, members []*Member) error {
@@ -203,6 +846,118 @@ func writeMembersToCsv(path string, members []*Member) error {
 	return nil
 }
 
+type CsvMemberStatus struct {
+	Member
+	Status       string `csv:"Status"`
+	LastPaid     string `csv:"LastPaid"`
+	CoveredUntil string `csv:"CoveredUntil"`
+}
+
+func writeMemberStatusesToCsv(path string, statuses []*memberStatus, membersDetails map[string]*Member) error {
+	csvStatuses := []*CsvMemberStatus{}
+	for _, status := range statuses {
+		member, ok := membersDetails[status.memberID]
+		if !ok {
+			member = &Member{MemberID: status.memberID}
+		}
+
+		csvStatuses = append(csvStatuses, &CsvMemberStatus{
+			*member,
+			status.status,
+			status.lastPaid.Format(ledgerDateFormat),
+			status.coveredUntil.Format(ledgerDateFormat),
+		})
+	}
+
+	statusFile, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer statusFile.Close()
+
+	return gocsv.MarshalFile(&csvStatuses, statusFile)
+}
+
+type CsvMemberBalance struct {
+	Member
+	Expected     string `csv:"Expected"`
+	Paid         string `csv:"Paid"`
+	Balance      string `csv:"Balance"`
+	ArrearsSince string `csv:"ArrearsSince"`
+}
+
+func writeMemberBalancesToCsv(path string, balances []*memberBalance, membersDetails map[string]*Member) error {
+	csvBalances := []*CsvMemberBalance{}
+	for _, balance := range balances {
+		member, ok := membersDetails[balance.memberID]
+		if !ok {
+			member = &Member{MemberID: balance.memberID}
+		}
+
+		arrearsSince := ""
+		if !balance.arrearsSince.IsZero() {
+			arrearsSince = balance.arrearsSince.Format("200601")
+		}
+
+		csvBalances = append(csvBalances, &CsvMemberBalance{
+			*member,
+			balance.expected.String(),
+			balance.paid.String(),
+			balance.balance().String(),
+			arrearsSince,
+		})
+	}
+
+	balanceFile, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer balanceFile.Close()
+
+	return gocsv.MarshalFile(&csvBalances, balanceFile)
+}
+
+type CsvMemberRefund struct {
+	Member
+	Paid          string `csv:"Paid"`
+	Refunded      string `csv:"Refunded"`
+	Net           string `csv:"Net"`
+	FullyRefunded bool   `csv:"FullyRefunded"`
+	Refunds       string `csv:"Refunds"`
+}
+
+func writeRefundsToCsv(path string, refunds []*memberRefund, membersDetails map[string]*Member) error {
+	csvRefunds := []*CsvMemberRefund{}
+	for _, refund := range refunds {
+		member, ok := membersDetails[refund.memberID]
+		if !ok {
+			member = &Member{MemberID: refund.memberID}
+		}
+
+		descriptions := []string{}
+		for _, txn := range refund.refunds {
+			descriptions = append(descriptions, txn.date.Format(ledgerDateFormat)+" "+txn.description)
+		}
+
+		csvRefunds = append(csvRefunds, &CsvMemberRefund{
+			*member,
+			refund.paid.String(),
+			refund.refunded.String(),
+			refund.net().String(),
+			refund.fullyRefunded(),
+			strings.Join(descriptions, "|"),
+		})
+	}
+
+	refundFile, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer refundFile.Close()
+
+	return gocsv.MarshalFile(&csvRefunds, refundFile)
+}
+
 func writeMemberIdsToCsv(path string, memberIds []string) error {
 	targetFile, err := os.Create(path)
 	if err != nil {
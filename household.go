@@ -0,0 +1,110 @@
+package main
+
+import (
+	"github.com/shopspring/decimal"
+)
+
+// A household is a reference listing more than one member ID, so one payment
+// pays for everyone in it.
+type allocation struct {
+	memberID  string
+	expected  decimal.Decimal
+	allocated decimal.Decimal
+}
+
+func (a *allocation) covered() bool {
+	return a.allocated.GreaterThanOrEqual(a.expected)
+}
+
+type partialPayment struct {
+	txn         *bankTxn
+	expected    decimal.Decimal
+	allocations []*allocation
+}
+
+func (p *partialPayment) coveredMemberIDs() (memberIDs []string) {
+	for _, allocation := range p.allocations {
+		if allocation.covered() {
+			memberIDs = append(memberIDs, allocation.memberID)
+		}
+	}
+
+	return memberIDs
+}
+
+/*
+The household's fee is split evenly between its members, with any rounding
+left on the last one. The payment is then handed out in the order the members
+are listed in the reference mapping, so the first member listed is covered
+first.
+*/
+func allocatePayment(amount decimal.Decimal, expected decimal.Decimal, memberIDs []string) (allocations []*allocation) {
+	share := expected.Div(decimal.New(int64(len(memberIDs)), 0)).Round(2)
+	remaining := amount
+	for i, memberID := range memberIDs {
+		memberExpected := share
+		if i == len(memberIDs)-1 {
+			memberExpected = expected.Sub(share.Mul(decimal.New(int64(i), 0)))
+		}
+
+		allocated := memberExpected
+		if remaining.LessThan(memberExpected) {
+			allocated = remaining
+		}
+
+		if allocated.IsNegative() {
+			allocated = decimal.Zero
+		}
+
+		allocations = append(allocations, &allocation{memberID, memberExpected, allocated})
+		remaining = remaining.Sub(allocated)
+	}
+
+	return allocations
+}
+
+// Only households with a fee category have a combined fee to check against.
+func identifyPartialPayments(txns []*bankTxn, references *referenceMatcher, schedule *feeSchedule) (partialPayments []*partialPayment, err error) {
+	for _, txn := range txns {
+		rule, ok := references.matchTxn(txn)
+		if !ok || len(rule.memberIDs) < 2 {
+			continue
+		}
+
+		correct, expected, err := schedule.checkAmount(txn.amount, rule)
+		if err != nil {
+			return nil, err
+		}
+
+		if correct || expected == nil || !txn.amount.LessThan(*expected) {
+			continue
+		}
+
+		partialPayments = append(partialPayments, &partialPayment{txn, *expected, allocatePayment(txn.amount, *expected, rule.memberIDs)})
+	}
+
+	return partialPayments, nil
+}
+
+// Household payments short of their fee are kept whatever their amount, so
+// they're taken out before anything is ignored for not being a fee amount.
+func splitPartialPayments(txns []*bankTxn, references *referenceMatcher, schedule *feeSchedule) (partialPayments []*partialPayment, partialTxns []*bankTxn, otherTxns []*bankTxn, err error) {
+	partialPayments, err = identifyPartialPayments(txns, references, schedule)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	partial := make(map[*bankTxn]bool)
+	for _, payment := range partialPayments {
+		partial[payment.txn] = true
+		partialTxns = append(partialTxns, payment.txn)
+	}
+
+	for _, txn := range txns {
+		if !partial[txn] {
+			otherTxns = append(otherTxns, txn)
+		}
+	}
+
+	return partialPayments, partialTxns, otherTxns, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestAllocatePayment(t *testing.T) {
+	testCases := []struct {
+		amount    decimal.Decimal
+		allocated []decimal.Decimal
+		covered   []string
+	}{
+		{decimal.New(30, 0), []decimal.Decimal{decimal.New(10, 0), decimal.New(10, 0), decimal.New(10, 0)}, []string{"A1", "A2", "A3"}},
+		{decimal.New(25, 0), []decimal.Decimal{decimal.New(10, 0), decimal.New(10, 0), decimal.New(5, 0)}, []string{"A1", "A2"}},
+		{decimal.New(5, 0), []decimal.Decimal{decimal.New(5, 0), decimal.Zero, decimal.Zero}, nil},
+	}
+
+	for _, testCase := range testCases {
+		allocations := allocatePayment(testCase.amount, decimal.New(30, 0), []string{"A1", "A2", "A3"})
+		for i, allocation := range allocations {
+			if !allocation.allocated.Equal(testCase.allocated[i]) {
+				t.Fatalf("%v: %v != %v", allocation.memberID, allocation.allocated, testCase.allocated[i])
+			}
+		}
+
+		payment := &partialPayment{allocations: allocations}
+		if covered := payment.coveredMemberIDs(); !reflect.DeepEqual(covered, testCase.covered) {
+			t.Fatalf("%v != %v", covered, testCase.covered)
+		}
+	}
+
+	allocations := allocatePayment(decimal.New(10, 0), decimal.New(10, 0), []string{"A1", "A2", "A3"})
+	if !allocations[0].expected.Equal(decimal.New(333, -2)) || !allocations[2].expected.Equal(decimal.New(334, -2)) {
+		t.Fatalf("Rounding not left on the last member: %v, %v", allocations[0].expected, allocations[2].expected)
+	}
+}
+
+func TestIdentifyPartialPayments(t *testing.T) {
+	schedule := &feeSchedule{
+		correctAmounts: []decimal.Decimal{decimal.New(185, -1)},
+		categories:     map[string]decimal.Decimal{"family": decimal.New(30, 0)},
+	}
+
+	refs := newReferenceMatcher()
+	rule, _ := refs.add(matchLiteral, "FAMILY", []string{"A1", "A2"})
+	rule.feeCategory = "family"
+	refs.add(matchLiteral, "JOINT", []string{"A3", "A4"})
+
+	short := &bankTxn{description: "FAMILY", amount: decimal.New(185, -1)}
+	over := &bankTxn{description: "FAMILY", amount: decimal.New(40, 0)}
+	paid := &bankTxn{description: "FAMILY", amount: decimal.New(30, 0)}
+	uncategorised := &bankTxn{description: "JOINT", amount: decimal.New(15, 0)}
+
+	partialPayments, err := identifyPartialPayments([]*bankTxn{short, over, paid, uncategorised}, refs, schedule)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(partialPayments) != 1 || partialPayments[0].txn != short {
+		t.Fatalf("%v != %v", partialPayments, short)
+	}
+
+	if covered := partialPayments[0].coveredMemberIDs(); !reflect.DeepEqual(covered, []string{"A1"}) {
+		t.Fatalf("%v != %v", covered, []string{"A1"})
+	}
+}
+
+func TestSplitPartialPayments(t *testing.T) {
+	schedule := &feeSchedule{
+		correctAmounts: []decimal.Decimal{decimal.New(185, -1)},
+		categories:     map[string]decimal.Decimal{"family": decimal.New(30, 0)},
+	}
+
+	refs := newReferenceMatcher()
+	rule, _ := refs.add(matchLiteral, "FAMILY", []string{"A1", "A2"})
+	rule.feeCategory = "family"
+
+	// Not one of the fee amounts, so filterInterestingTxns would ignore it.
+	short := &bankTxn{description: "FAMILY", amount: decimal.New(20, 0)}
+	raffle := &bankTxn{description: "RAFFLE", amount: decimal.New(20, 0)}
+
+	partialPayments, partialTxns, otherTxns, err := splitPartialPayments([]*bankTxn{short, raffle}, refs, schedule)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(partialPayments) != 1 || !reflect.DeepEqual(partialTxns, []*bankTxn{short}) || !reflect.DeepEqual(otherTxns, []*bankTxn{raffle}) {
+		t.Fatalf("Wrong split: %v, %v, %v", partialPayments, partialTxns, otherTxns)
+	}
+
+	if covered := partialPayments[0].coveredMemberIDs(); !reflect.DeepEqual(covered, []string{"A1"}) {
+		t.Fatalf("%v != %v", covered, []string{"A1"})
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"sort"
+)
+
+const (
+	txnIgnored     = "ignored"
+	txnUnmatched   = "unmatched"
+	txnIncorrect   = "incorrect"
+	txnMatched     = "matched"
+	txnWrongAmount = "wrong-amount"
+	txnRefund      = "refund"
+)
+
+type ledgerEntry struct {
+	txn            *bankTxn
+	classification string
+	memberIDs      []string
+	matchedRule    string
+	recordedIn     string
+}
+
+func (e *ledgerEntry) isMembershipPayment() bool {
+	return e.classification == txnMatched || e.classification == txnIncorrect
+}
+
+type ledger struct {
+	entries []*ledgerEntry
+}
+
+// The bank's own transaction ID is the best key when the statement has one.
+func (t *bankTxn) key() string {
+	if len(t.fitID) > 0 {
+		return "fitid|" + t.fitID
+	}
+
+	return t.date.Format(ledgerDateFormat) + "|" + t.description + "|" + t.amount.String()
+}
+
+/*
+Bank exports overlap, so the same transaction can turn up in more than one
+statement. Identical transactions are matched up by occurrence: the second
+identical transaction in a batch only matches the second identical entry in
+the ledger. Entries that are already present are reclassified rather than
+duplicated, so re-running a month after fixing the reference mapping updates
+the ledger.
+*/
+func (l *ledger) record(batch []*ledgerEntry) (added int) {
+	existing := make(map[string][]*ledgerEntry)
+	for _, entry := range l.entries {
+		key := entry.txn.key()
+		existing[key] = append(existing[key], entry)
+	}
+
+	seen := make(map[string]int)
+	for _, entry := range batch {
+		key := entry.txn.key()
+		occurrence := seen[key]
+		seen[key]++
+		if occurrence < len(existing[key]) {
+			previous := existing[key][occurrence]
+			previous.classification = entry.classification
+			previous.memberIDs = entry.memberIDs
+			previous.matchedRule = entry.matchedRule
+		} else {
+			l.entries = append(l.entries, entry)
+			added++
+		}
+	}
+
+	sort.SliceStable(l.entries, func(i, j int) bool {
+		return l.entries[i].txn.date.Before(l.entries[j].txn.date)
+	})
+
+	return added
+}
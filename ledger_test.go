@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestLedgerRecord(t *testing.T) {
+	date := time.Date(2018, 1, 16, 0, 0, 0, 0, time.UTC)
+	l := &ledger{}
+	firstBatch := []*ledgerEntry{
+		{&bankTxn{date: date, description: "JOE BLOGGS", amount: decimal.New(185, -1)}, txnUnmatched, nil, "", "201801"},
+		{&bankTxn{date: date, description: "JOE BLOGGS", amount: decimal.New(185, -1)}, txnUnmatched, nil, "", "201801"},
+	}
+	overlappingBatch := []*ledgerEntry{
+		{&bankTxn{date: date, description: "JOE BLOGGS", amount: decimal.New(185, -1)}, txnMatched, []string{"A123456"}, "literal:JOE BLOGGS", "201802"},
+		{&bankTxn{date: date.AddDate(0, 1, 0), description: "JOE BLOGGS", amount: decimal.New(185, -1)}, txnMatched, []string{"A123456"}, "literal:JOE BLOGGS", "201802"},
+	}
+
+	if added := l.record(firstBatch); added != 2 {
+		t.Fatalf("Expected identical transactions in one batch to both be added, added %v", added)
+	}
+
+	if added := l.record(overlappingBatch); added != 1 {
+		t.Fatalf("Expected only the new transaction to be added, added %v", added)
+	}
+
+	if len(l.entries) != 3 {
+		t.Fatalf("Ledger entry count is wrong (%v, %v)", len(l.entries), 3)
+	}
+
+	if l.entries[0].classification != txnMatched || l.entries[0].recordedIn != "201801" {
+		t.Fatalf("Existing entry was not reclassified: %v", *l.entries[0])
+	}
+
+	if l.entries[1].classification != txnUnmatched {
+		t.Fatalf("Second identical entry should be untouched: %v", *l.entries[1])
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	statusJoined   = "joined"
+	statusActive   = "active"
+	statusLapsing  = "lapsing"
+	statusLapsed   = "lapsed"
+	statusRejoined = "rejoined"
+)
+
+type memberStatus struct {
+	memberID     string
+	status       string
+	lastPaid     time.Time
+	coveredUntil time.Time
+}
+
+func (s *memberStatus) isPaying() bool {
+	return s.status != statusLapsed
+}
+
+/*
+Works out where each member is in their membership lifecycle for the period
+from-until. Payments made after the period are ignored so re-running an old
+month gives the same answer. A member whose cover ran out no more than grace
+before the period started is lapsing rather than lapsed, and a member whose
+cover had run out for longer than grace before paying again has rejoined.
+*/
+func identifyMemberStatuses(coverage map[string][]coverageWindow, from, until time.Time, grace time.Duration) (statuses []*memberStatus) {
+	statuses = []*memberStatus{}
+	for memberID, windows := range coverage {
+		var status *memberStatus
+		var previousEnd time.Time
+		covered := false
+		for _, window := range windows {
+			if !window.from.Before(until) {
+				continue
+			}
+
+			if status == nil {
+				status = &memberStatus{memberID: memberID}
+			}
+
+			if window.paid.After(status.lastPaid) {
+				status.lastPaid = window.paid
+			}
+
+			if window.until.After(status.coveredUntil) {
+				status.coveredUntil = window.until
+			}
+
+			if window.from.Before(from) && window.until.After(previousEnd) {
+				previousEnd = window.until
+			}
+
+			if window.overlaps(from, until) {
+				covered = true
+			}
+		}
+
+		if status == nil {
+			continue
+		}
+
+		if covered {
+			if previousEnd.IsZero() {
+				status.status = statusJoined
+			} else if from.Sub(previousEnd) > grace {
+				status.status = statusRejoined
+			} else {
+				status.status = statusActive
+			}
+		} else if from.Sub(status.coveredUntil) <= grace {
+			status.status = statusLapsing
+		} else {
+			status.status = statusLapsed
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].memberID < statuses[j].memberID
+	})
+
+	return statuses
+}
+
+func payingMemberIds(statuses []*memberStatus) (memberIds []string) {
+	memberIds = []string{}
+	for _, status := range statuses {
+		if status.isPaying() {
+			memberIds = append(memberIds, status.memberID)
+		}
+	}
+
+	return memberIds
+}
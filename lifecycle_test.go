@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func newTestLedgerEntry(year int, month time.Month, day int, description string, amount decimal.Decimal, memberIDs ...string) *ledgerEntry {
+	classification := txnMatched
+	if len(memberIDs) == 0 {
+		classification = txnUnmatched
+	}
+
+	return &ledgerEntry{
+		&bankTxn{date: time.Date(year, month, day, 0, 0, 0, 0, time.UTC), description: description, amount: amount},
+		classification,
+		memberIDs,
+		"",
+		"",
+	}
+}
+
+func TestIdentifyMemberStatuses(t *testing.T) {
+	schedules := []*feeSchedule{
+		{
+			effectiveFrom:  time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC),
+			correctAmounts: []decimal.Decimal{decimal.New(185, -1)},
+			annualAmounts:  []decimal.Decimal{decimal.New(200, 0)},
+		},
+	}
+	monthly := decimal.New(185, -1)
+	entries := []*ledgerEntry{
+		newTestLedgerEntry(2018, 1, 3, "ACTIVE", monthly, "A1"),
+		newTestLedgerEntry(2018, 2, 3, "ACTIVE", monthly, "A1"),
+		newTestLedgerEntry(2018, 2, 10, "JOINED", monthly, "A2"),
+		newTestLedgerEntry(2017, 5, 10, "ANNUAL", decimal.New(200, 0), "A3"),
+		newTestLedgerEntry(2018, 1, 20, "LAPSING", monthly, "A4"),
+		newTestLedgerEntry(2017, 10, 1, "LAPSED", monthly, "A5"),
+		newTestLedgerEntry(2017, 9, 1, "REJOINED", monthly, "A6"),
+		newTestLedgerEntry(2018, 2, 5, "REJOINED", monthly, "A6"),
+		newTestLedgerEntry(2018, 3, 5, "FUTURE", monthly, "A7"),
+		newTestLedgerEntry(2018, 2, 3, "NO MATCH", monthly),
+	}
+	expectedStatuses := []struct {
+		memberID string
+		status   string
+	}{
+		{"A1", statusActive},
+		{"A2", statusJoined},
+		{"A3", statusActive},
+		{"A4", statusLapsing},
+		{"A5", statusLapsed},
+		{"A6", statusRejoined},
+	}
+	expectedPayingMemberIds := []string{"A1", "A2", "A3", "A4", "A6"}
+
+	from, until := monthPeriod(time.Date(2018, 2, 1, 0, 0, 0, 0, time.UTC))
+	coverage := identifyCoverage(entries, schedules)
+	actualStatuses := identifyMemberStatuses(coverage, from, until, 30*24*time.Hour)
+
+	if len(actualStatuses) != len(expectedStatuses) {
+		t.Fatalf(
+			"Status counts are not the same (%v, %v)",
+			len(actualStatuses),
+			len(expectedStatuses),
+		)
+	}
+
+	for i := range expectedStatuses {
+		if expectedStatuses[i].memberID != actualStatuses[i].memberID || expectedStatuses[i].status != actualStatuses[i].status {
+			t.Fatalf("%v != %v", expectedStatuses[i], *actualStatuses[i])
+		}
+	}
+
+	actualPayingMemberIds := payingMemberIds(actualStatuses)
+	if len(actualPayingMemberIds) != len(expectedPayingMemberIds) {
+		t.Fatalf(
+			"Paying member counts are not the same (%v, %v)",
+			len(actualPayingMemberIds),
+			len(expectedPayingMemberIds),
+		)
+	}
+
+	for i := range expectedPayingMemberIds {
+		if expectedPayingMemberIds[i] != actualPayingMemberIds[i] {
+			t.Fatalf("%v != %v", expectedPayingMemberIds[i], actualPayingMemberIds[i])
+		}
+	}
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/shopspring/decimal"
@@ -13,6 +16,15 @@ const (
 	DefaultIgnoreTxnsPath              = "ignored_txns.csv"
 	DefaultIncorrectMembershipTxnsPath = "incorrect_membership_txns.csv"
 	DefaultUnmatchedTxnsPath           = "unmatched_txns.csv"
+	DefaultWrongAmountTxnsPath         = "wrong_amount_txns.csv"
+	DefaultPartialPaymentsPath         = "partial_payments.csv"
+	DefaultArrearsPath                 = "arrears.csv"
+	DefaultRefundsPath                 = "refunds.csv"
+	DefaultOutOfPeriodTxnsPath         = "out_of_period_txns.csv"
+	DefaultDuplicatePaymentsPath       = "duplicate_payments.csv"
+	DefaultSuggestedReferencesPath     = "suggested_references.csv"
+	DefaultCreditBalancesPath          = "credit_balances.csv"
+	DefaultExplainPath                 = "explain.csv"
 	DefaultPaidMembersPath             = "paid_members.csv"
 	DefaultUnmatchedMemberIDsPath      = "unmatched_memberids.csv"
 	DefaultAllMembersPath              = "all_members.csv"
@@ -21,35 +33,73 @@ const (
 	DefaultConsentingEmailsPath        = "consenting_emails.csv"
 	DefaultEmailListPath               = "email_list.csv"
 	DefaultWithdrawEmailsPath          = "withdraw_emails.csv"
+	DefaultFeeSchedulePath             = "fee_schedule.yaml"
+	DefaultBankTxnsPath                = "bank_acct_txns.csv"
+	DefaultOfxBankTxnsPath             = "bank_acct_txns.ofx"
+	DefaultQfxBankTxnsPath             = "bank_acct_txns.qfx"
+	DefaultBankFormatsPath             = "bank_formats.yaml"
+	DefaultMemberStatusPath            = "member_status.csv"
+	DefaultReferenceMappingsPath       = "reference_member_mappings.csv"
+	DefaultIgnoreListPath              = "ignored_references.csv"
+	DefaultDecisionsPath               = "decisions.csv"
+	DefaultImportedDecisionsPath       = "decisions_imported.csv"
 )
 
+type runConfig struct {
+	month               time.Time
+	bankFormat          string
+	gracePeriod         time.Duration
+	referenceFirst      bool
+	explain             bool
+	ignoreBalanceBreaks bool
+	excludeOutOfPeriod  bool
+}
+
 type membership struct {
-	references map[string][]string
-	members    map[string]*Member
-	newMembers []*Member
+	references   *referenceMatcher
+	members      map[string]*Member
+	newMembers   []*Member
+	feeSchedules []*feeSchedule
+	ledger       *ledger
+	ignoreList   *ignoreList
+	txnTypes     txnTypeMapping
 }
 
 type transactions struct {
-	ignored   []*bankTxn
-	incorrect []*bankTxn
-	candidate []*bankTxn
-	unmatched []*bankTxn
+	all         []*bankTxn
+	ignored     []*bankTxn
+	incorrect   []*bankTxn
+	candidate   []*bankTxn
+	unmatched   []*bankTxn
+	wrongAmount []*bankTxn
+	refunds     []*bankTxn
+	outOfPeriod []*bankTxn
+	suggestions map[*bankTxn][]*referenceSuggestion
+	names       []*nameSuggestion
+	expected    map[*bankTxn]decimal.Decimal
+	partial     []*partialPayment
+	duplicates  []*duplicatePayment
+	classified  map[*bankTxn]*ledgerEntry
 }
 
 type members struct {
 	paying       []*Member
 	unmatchedIDs []string
+	statuses     []*memberStatus
+	balances     []*memberBalance
+	refunds      []*memberRefund
 }
 
 type activeMembers struct {
-	txns    transactions
-	members members
+	txns        transactions
+	members     members
+	feeSchedule *feeSchedule
 }
 
 func newMembership(fc *fileConfig) (*membership, error) {
 	var err error
 	m := membership{}
-	m.references, err = loadMemberReferencesFromCsv(fc.getSourcePath("reference_member_mappings.csv"))
+	m.references, err = loadMemberReferencesFromCsv(fc.getSourcePath(DefaultReferenceMappingsPath))
 	if err != nil {
 		return nil, err
 	}
@@ -64,27 +114,166 @@ func newMembership(fc *fileConfig) (*membership, error) {
 		panic(err)
 	}
 
+	m.feeSchedules, err = loadFeeSchedulesFromYaml(fc.getSourcePath(DefaultFeeSchedulePath))
+	if err != nil {
+		return nil, err
+	}
+
+	m.ledger, err = loadLedgerFromCsv(fc.getLedgerPath())
+	if err != nil {
+		return nil, err
+	}
+
+	m.ignoreList, err = loadIgnoreListFromCsv(fc.getSourcePath(DefaultIgnoreListPath))
+	if err != nil {
+		return nil, err
+	}
+
 	return &m, nil
 }
 
-func (m *membership) loadAndFilterTxns(txnsPath string, correctMembershipAmounts, otherMembershipAmounts []decimal.Decimal) (am *activeMembers, err error) {
-	txns, err := loadTxnsFromCsv(txnsPath)
+func (m *membership) classifyTxns(txns *transactions, month string) (entries []*ledgerEntry) {
+	incorrect := make(map[*bankTxn]bool)
+	for _, txn := range txns.incorrect {
+		incorrect[txn] = true
+	}
+
+	partial := make(map[*bankTxn]*partialPayment)
+	for _, payment := range txns.partial {
+		partial[payment.txn] = payment
+	}
+
+	for _, txn := range txns.ignored {
+		entries = append(entries, &ledgerEntry{txn: txn, classification: txnIgnored, recordedIn: month})
+	}
+
+	for _, txn := range txns.refunds {
+		rule, _ := m.references.matchTxn(txn)
+		entries = append(entries, &ledgerEntry{txn, txnRefund, rule.memberIDs, rule.String(), month})
+	}
+
+	for _, txn := range txns.wrongAmount {
+		rule, _ := m.references.matchTxn(txn)
+		entries = append(entries, &ledgerEntry{txn, txnWrongAmount, rule.memberIDs, rule.String(), month})
+	}
+
+	for _, txn := range txns.candidate {
+		rule, ok := m.references.matchTxn(txn)
+		if !ok {
+			entries = append(entries, &ledgerEntry{txn: txn, classification: txnUnmatched, recordedIn: month})
+		} else if payment, ok := partial[txn]; ok {
+			entries = append(entries, &ledgerEntry{txn, txnIncorrect, payment.coveredMemberIDs(), rule.String(), month})
+		} else if incorrect[txn] {
+			entries = append(entries, &ledgerEntry{txn, txnIncorrect, rule.memberIDs, rule.String(), month})
+		} else {
+			entries = append(entries, &ledgerEntry{txn, txnMatched, rule.memberIDs, rule.String(), month})
+		}
+	}
+
+	return entries
+}
+
+/*
+The month's transactions are recorded in the ledger, and members are paying
+unless the payments in the ledger show they have lapsed. The current month's
+transactions alone drive the ignored/incorrect/unmatched reports.
+
+Normally anything that isn't a fee amount is ignored without looking at its
+reference. With referenceFirst, references are matched first so payments of
+the wrong amount from known members are reported rather than ignored. Either
+way, household payments short of their fee are always kept.
+*/
+func (m *membership) loadAndFilterTxns(txnsPath string, rc *runConfig) (am *activeMembers, err error) {
+	allTxns, err := loadTxnsFromFile(txnsPath, rc.bankFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	breaks := checkBalanceContinuity(allTxns)
+	for _, balanceBreak := range breaks {
+		fmt.Fprintf(os.Stderr, "WARNING: balance break: %v\n", balanceBreak)
+	}
+
+	if len(breaks) > 0 && !rc.ignoreBalanceBreaks {
+		return nil, fmt.Errorf("The running balance in %v breaks %v times, so rows are missing or the export is incomplete. Check the statement, or run with --ignoreBalanceBreaks", txnsPath, len(breaks))
+	}
+
+	from, until := monthPeriod(rc.month)
+	periodTxns, outOfPeriodTxns, err := checkStatementPeriod(allTxns, from, until)
+	if err != nil {
+		return nil, err
+	}
+
+	if !rc.excludeOutOfPeriod {
+		periodTxns = allTxns
+	}
+
+	typedTxns, typeIgnoredTxns := m.txnTypes.apply(periodTxns)
+	txns, debitTxns := filterCreditTxns(typedTxns)
+	txns, listedTxns := m.ignoreList.filter(txns)
+	debitTxns, _ = m.ignoreList.filter(debitTxns)
+
+	feeSchedule, err := selectFeeSchedule(m.feeSchedules, rc.month)
+	if err != nil {
+		return nil, err
+	}
+
+	var transactions = transactions{all: allTxns, outOfPeriod: outOfPeriodTxns}
+	transactions.refunds, _ = splitKnownReferences(debitTxns, m.references)
+	var partialTxns []*bankTxn
+	transactions.partial, partialTxns, txns, err = splitPartialPayments(txns, m.references, feeSchedule)
+	if err != nil {
+		return nil, err
+	}
+
+	if rc.referenceFirst {
+		knownTxns, unknownTxns := splitKnownReferences(txns, m.references)
+		knownCandidates, wrongAmount := filterInterestingTxns(knownTxns, feeSchedule.allAmounts())
+		unknownCandidates, unrelated := filterInterestingTxns(unknownTxns, feeSchedule.allAmounts())
+		transactions.candidate = append(knownCandidates, unknownCandidates...)
+		transactions.wrongAmount = wrongAmount
+		transactions.ignored = unrelated
+	} else {
+		transactions.candidate, transactions.ignored = filterInterestingTxns(txns, feeSchedule.allAmounts())
+	}
+
+	transactions.candidate = append(transactions.candidate, partialTxns...)
+	transactions.ignored = append(transactions.ignored, listedTxns...)
+	transactions.ignored = append(transactions.ignored, typeIgnoredTxns...)
+	transactions.incorrect, transactions.expected, err = identifyIncorrectTxns(transactions.candidate, m.references, feeSchedule)
 	if err != nil {
 		return nil, err
 	}
 
-	var transactions = transactions{}
-	membershipAmounts := append(correctMembershipAmounts, otherMembershipAmounts...)
-	transactions.candidate, transactions.ignored = filterInterestingTxns(txns, membershipAmounts)
-	_, transactions.incorrect = filterInterestingTxns(transactions.candidate, correctMembershipAmounts)
-	var memberIds []string
-	memberIds, transactions.unmatched = identifyMembers(transactions.candidate, m.references)
+	_, transactions.unmatched = identifyMembers(transactions.candidate, m.references)
+	monthCandidates, _ := splitByPeriod(transactions.candidate, from, until)
+	transactions.duplicates = identifyDuplicatePayments(monthCandidates, m.references)
+	transactions.suggestions = make(map[*bankTxn][]*referenceSuggestion)
+	for _, txn := range transactions.unmatched {
+		transactions.suggestions[txn] = suggestReferences(txn, m.references)
+	}
+	transactions.names = suggestMemberReferences(transactions.unmatched, m.members)
+	entries := m.classifyTxns(&transactions, rc.month.Format("200601"))
+	transactions.classified = make(map[*bankTxn]*ledgerEntry)
+	for _, entry := range entries {
+		transactions.classified[entry.txn] = entry
+	}
+
+	m.ledger.record(entries)
+	coverage := identifyCoverage(m.ledger.entries, m.feeSchedules)
 	var members = members{}
-	members.paying, members.unmatchedIDs = matchMembers(m.members, memberIds)
+	members.statuses = identifyMemberStatuses(coverage, from, until, rc.gracePeriod)
+	members.refunds = identifyRefunds(m.ledger.entries, from, until)
+	members.paying, members.unmatchedIDs = matchMembers(m.members, removeRefundedMemberIds(payingMemberIds(members.statuses), members.refunds))
+	members.balances, err = identifyBalances(m.ledger.entries, members.statuses, m.references, m.feeSchedules, rc.month)
+	if err != nil {
+		return nil, err
+	}
 
 	am = new(activeMembers)
 	am.txns = transactions
 	am.members = members
+	am.feeSchedule = feeSchedule
 
 	return am, err
 }
@@ -121,59 +310,31 @@ func createEmailList(allMembers []*Member, consentingEmailAddresses []string, wi
 	return emailAddresses
 }
 
-func main() {
-	var (
-		currentYyyyMm = kingpin.Flag("currentYyyyMm", "override date string (for file organisation)").Default(time.Now().UTC().Format("200601")).String()
-		baseDir       = kingpin.Arg("baseDir", "the base directory for the files").Required().String()
-	)
-
-	kingpin.Parse()
-
-	folderDate, err := time.Parse("200601", *currentYyyyMm)
-	if err != nil {
-		panic(err)
-	}
-
-	correctMembershipAmounts := []decimal.Decimal{
-		decimal.New(185, -1),
-		decimal.New(30, 0),
-	}
-	membershipAmounts := []decimal.Decimal{
-		decimal.New(165, -1),
-		decimal.New(15, 0),
-		decimal.New(18, 0),
-		decimal.New(25, 0),
-	}
-	membershipAmounts = append(membershipAmounts, correctMembershipAmounts...)
-	fileConfig := fileConfig{
-		*baseDir,
-		folderDate.Format("200601"),
-		folderDate.AddDate(0, -1, 0).Format("200601"),
-	}
-
+func runMembership(fileConfig *fileConfig, membership *membership, rc *runConfig) {
 	ignoreTxnsPath := fileConfig.getCurrentDestinationPath(DefaultIgnoreTxnsPath)
 	incorrectMembershipTxnsPath := fileConfig.getCurrentDestinationPath(DefaultIncorrectMembershipTxnsPath)
 	unmatchedTxnsPath := fileConfig.getCurrentDestinationPath(DefaultUnmatchedTxnsPath)
+	wrongAmountTxnsPath := fileConfig.getCurrentDestinationPath(DefaultWrongAmountTxnsPath)
+	partialPaymentsPath := fileConfig.getCurrentDestinationPath(DefaultPartialPaymentsPath)
+	explainPath := fileConfig.getCurrentDestinationPath(DefaultExplainPath)
 	paidMembersPath := fileConfig.getCurrentDestinationPath(DefaultPaidMembersPath)
 	unmatchedMemberIDsPath := fileConfig.getCurrentDestinationPath(DefaultUnmatchedMemberIDsPath)
 	allMembersPath := fileConfig.getCurrentDestinationPath(DefaultAllMembersPath)
 	previousAllMembersPath := fileConfig.getPreviousDestinationPath(DefaultAllMembersPath)
 	leaversPath := fileConfig.getCurrentDestinationPath(DefaultLeaversPath)
 	joinersPath := fileConfig.getCurrentDestinationPath(DefaultJoinersPath)
+	memberStatusPath := fileConfig.getCurrentDestinationPath(DefaultMemberStatusPath)
+	arrearsPath := fileConfig.getCurrentDestinationPath(DefaultArrearsPath)
+	creditBalancesPath := fileConfig.getCurrentDestinationPath(DefaultCreditBalancesPath)
+	refundsPath := fileConfig.getCurrentDestinationPath(DefaultRefundsPath)
+	outOfPeriodTxnsPath := fileConfig.getCurrentDestinationPath(DefaultOutOfPeriodTxnsPath)
+	duplicatePaymentsPath := fileConfig.getCurrentDestinationPath(DefaultDuplicatePaymentsPath)
+	suggestedReferencesPath := fileConfig.getCurrentDestinationPath(DefaultSuggestedReferencesPath)
 	consentingEmailsPath := fileConfig.getSourcePath(DefaultConsentingEmailsPath)
 	emailListPath := fileConfig.getCurrentDestinationPath(DefaultEmailListPath)
 	withdrawEmailsPath := fileConfig.getSourcePath(DefaultWithdrawEmailsPath)
 
-	membership, err := newMembership(&fileConfig)
-	if err != nil {
-		panic(err)
-	}
-
-	fmt.Printf("Loaded %v references.\n", len(membership.references))
-	fmt.Printf("Loaded %v members details.\n", len(membership.members))
-	fmt.Printf("Loaded %v new members details.\n", len(membership.newMembers))
-
-	activeMembers, err := membership.loadAndFilterTxns(fileConfig.getCurrentSourcePath("bank_acct_txns.csv"), correctMembershipAmounts, membershipAmounts)
+	activeMembers, err := membership.loadAndFilterTxns(fileConfig.getBankTxnsPath(), rc)
 	if err != nil {
 		panic(err)
 	}
@@ -191,10 +352,27 @@ func main() {
 		panic(err)
 	}
 
-	fmt.Printf("Loaded %v transactions.\n", len(activeMembers.txns.candidate)+len(activeMembers.txns.ignored))
+	fmt.Printf("Loaded %v transactions.\n", len(activeMembers.txns.candidate)+len(activeMembers.txns.ignored)+len(activeMembers.txns.wrongAmount))
+	if rc.explain {
+		traces := membership.explainTxns(activeMembers.txns.all, activeMembers, rc)
+		fmt.Printf("Writing %v transaction traces to %v.\n", len(traces), explainPath)
+		err = writeTracesToCsvFile(explainPath, traces)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	if len(activeMembers.txns.outOfPeriod) > 0 {
+		fmt.Printf("Writing %v transactions from outside %v to %v.\n", len(activeMembers.txns.outOfPeriod), rc.month.Format("200601"), outOfPeriodTxnsPath)
+		err = writeTxnsToCsv(outOfPeriodTxnsPath, activeMembers.txns.outOfPeriod, activeMembers.txns.classified)
+		if err != nil {
+			panic(err)
+		}
+	}
+
 	if len(activeMembers.txns.ignored) > 0 {
 		fmt.Printf("Writing %v ignored records to %v.\n", len(activeMembers.txns.ignored), ignoreTxnsPath)
-		err = writeTxnsToCsv(ignoreTxnsPath, activeMembers.txns.ignored)
+		err = writeTxnsToCsv(ignoreTxnsPath, activeMembers.txns.ignored, activeMembers.txns.classified)
 		if err != nil {
 			panic(err)
 		}
@@ -202,7 +380,31 @@ func main() {
 
 	if len(activeMembers.txns.incorrect) > 0 {
 		fmt.Printf("Writing %v incorrect membership records to %v.\n", len(activeMembers.txns.incorrect), incorrectMembershipTxnsPath)
-		err = writeTxnsToCsv(incorrectMembershipTxnsPath, activeMembers.txns.incorrect)
+		err = writeIncorrectTxnsToCsv(incorrectMembershipTxnsPath, activeMembers.txns.incorrect, activeMembers.txns.classified, activeMembers.txns.expected)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	if len(activeMembers.txns.partial) > 0 {
+		fmt.Printf("Writing %v partial household payments to %v.\n", len(activeMembers.txns.partial), partialPaymentsPath)
+		err = writePartialPaymentsToCsv(partialPaymentsPath, activeMembers.txns.partial)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	if len(activeMembers.txns.duplicates) > 0 {
+		fmt.Printf("Writing %v members paid more than once to %v.\n", len(activeMembers.txns.duplicates), duplicatePaymentsPath)
+		err = writeDuplicatePaymentsToCsv(duplicatePaymentsPath, activeMembers.txns.duplicates)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	if len(activeMembers.txns.wrongAmount) > 0 {
+		fmt.Printf("Writing %v wrong amount transactions from known members to %v.\n", len(activeMembers.txns.wrongAmount), wrongAmountTxnsPath)
+		err = writeTxnsToCsv(wrongAmountTxnsPath, activeMembers.txns.wrongAmount, activeMembers.txns.classified)
 		if err != nil {
 			panic(err)
 		}
@@ -210,7 +412,23 @@ func main() {
 
 	if len(activeMembers.txns.unmatched) > 0 {
 		fmt.Printf("Writing %v unmatched transactions to %v.\n", len(activeMembers.txns.unmatched), unmatchedTxnsPath)
-		err = writeTxnsToCsv(unmatchedTxnsPath, activeMembers.txns.unmatched)
+		err = writeUnmatchedTxnsToCsv(unmatchedTxnsPath, activeMembers.txns.unmatched, activeMembers.txns.classified, activeMembers.txns.suggestions)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	if len(activeMembers.txns.names) > 0 {
+		fmt.Printf("Writing %v references suggested from member names to %v.\n", len(activeMembers.txns.names), suggestedReferencesPath)
+		err = writeSuggestedReferencesToCsv(suggestedReferencesPath, activeMembers.txns.names)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	if len(activeMembers.members.refunds) > 0 {
+		fmt.Printf("Writing %v refunded members to %v.\n", len(activeMembers.members.refunds), refundsPath)
+		err = writeRefundsToCsv(refundsPath, activeMembers.members.refunds, membership.members)
 		if err != nil {
 			panic(err)
 		}
@@ -230,6 +448,32 @@ func main() {
 		panic(err)
 	}
 
+	fmt.Printf("Writing %v member statuses to %v\n", len(activeMembers.members.statuses), memberStatusPath)
+	err = writeMemberStatusesToCsv(memberStatusPath, activeMembers.members.statuses, membership.members)
+	if err != nil {
+		panic(err)
+	}
+
+	arrears, credit := filterBalances(activeMembers.members.balances)
+	fmt.Printf("Writing %v members in arrears to %v\n", len(arrears), arrearsPath)
+	err = writeMemberBalancesToCsv(arrearsPath, arrears, membership.members)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("Writing %v members in credit to %v\n", len(credit), creditBalancesPath)
+	err = writeMemberBalancesToCsv(creditBalancesPath, credit, membership.members)
+	if err != nil {
+		panic(err)
+	}
+
+	ledgerPath := fileConfig.getLedgerPath()
+	fmt.Printf("Writing %v ledger entries to %v\n", len(membership.ledger.entries), ledgerPath)
+	err = writeLedgerToCsv(ledgerPath, membership.ledger)
+	if err != nil {
+		panic(err)
+	}
+
 	allMembers := append(activeMembers.members.paying, membership.newMembers...)
 	fmt.Printf("Writing %v members details to %v\n", len(allMembers), allMembersPath)
 	err = writeMembersToCsv(allMembersPath, allMembers)
@@ -282,3 +526,340 @@ func main() {
 		}
 	}
 }
+
+func exportDecisions(fileConfig *fileConfig, membership *membership, rc *runConfig) {
+	decisionsPath := fileConfig.getCurrentSourcePath(DefaultDecisionsPath)
+	_, err := os.Stat(decisionsPath)
+	if err == nil {
+		panic(fmt.Errorf("%v already exists, import or delete it first", decisionsPath))
+	}
+
+	activeMembers, err := membership.loadAndFilterTxns(fileConfig.getBankTxnsPath(), rc)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("Writing %v unmatched transactions to %v.\n", len(activeMembers.txns.unmatched), decisionsPath)
+	err = writeDecisionsToCsv(decisionsPath, activeMembers.txns.unmatched, activeMembers.txns.suggestions)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func importDecisions(fileConfig *fileConfig, membership *membership) {
+	decisionsPath := fileConfig.getCurrentSourcePath(DefaultDecisionsPath)
+	decisions, err := loadDecisionsFromCsv(decisionsPath)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("Loaded %v decisions from %v.\n", len(decisions), decisionsPath)
+	newReferences, newIgnoreRules, problems := resolveDecisions(decisions, membership.members, membership.references)
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			fmt.Fprintf(os.Stderr, "%v\n", problem)
+		}
+
+		panic(fmt.Errorf("%v problems in %v, nothing imported", len(problems), decisionsPath))
+	}
+
+	if len(newReferences) > 0 {
+		referencesPath := fileConfig.getSourcePath(DefaultReferenceMappingsPath)
+		fmt.Printf("Adding %v references to %v.\n", len(newReferences), referencesPath)
+		err = addMemberReferencesToCsv(referencesPath, newReferences)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	if len(newIgnoreRules) > 0 {
+		ignoreListPath := fileConfig.getSourcePath(DefaultIgnoreListPath)
+		membership.ignoreList.rules = append(membership.ignoreList.rules, newIgnoreRules...)
+		fmt.Printf("Adding %v ignore rules to %v.\n", len(newIgnoreRules), ignoreListPath)
+		err = writeIgnoreListToCsv(ignoreListPath, membership.ignoreList)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	importedPath := fileConfig.getCurrentSourcePath(DefaultImportedDecisionsPath)
+	fmt.Printf("Moving %v to %v.\n", decisionsPath, importedPath)
+	err = os.Rename(decisionsPath, importedPath)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func checkReferences(membership *membership, check *referenceCheck) {
+	problems := check.checkReferences(membership.references, membership.members, membership.ledger.entries)
+	err := writeReferenceProblemsToCsv(os.Stdout, problems)
+	if err != nil {
+		panic(err)
+	}
+
+	failing := 0
+	for _, problem := range problems {
+		if problem.failing() {
+			failing++
+		}
+	}
+
+	if failing > 0 {
+		fmt.Fprintf(os.Stderr, "Found %v problems with the reference mapping.\n", failing)
+		os.Exit(1)
+	}
+}
+
+func loadReferenceMapping(fileConfig *fileConfig) (*referenceMapping, string) {
+	referencesPath := fileConfig.getSourcePath(DefaultReferenceMappingsPath)
+	rows, err := loadMemberReferenceRowsFromCsv(referencesPath)
+	if err != nil {
+		panic(err)
+	}
+
+	mapping, err := newReferenceMapping(rows)
+	if err != nil {
+		panic(err)
+	}
+
+	return mapping, referencesPath
+}
+
+func loadRefMembers(fileConfig *fileConfig) map[string]*Member {
+	members, err := loadMembershipDetailsFromCsv(fileConfig.getSourcePath("membership_details.csv"))
+	if err != nil {
+		panic(err)
+	}
+
+	return members
+}
+
+// Edits are made to the rows of the file, which is rewritten in sorted order.
+func editReferences(fileConfig *fileConfig, action string, edit func(*referenceMapping) (*MemberReference, error)) {
+	mapping, referencesPath := loadReferenceMapping(fileConfig)
+	row, err := edit(mapping)
+	if err != nil {
+		panic(err)
+	}
+
+	mapping.sort()
+	fmt.Printf("%v %v (%v).\n", action, row, row.MemberIDs)
+	fmt.Printf("Writing %v references to %v.\n", len(mapping.rows), referencesPath)
+	err = writeMemberReferencesToCsvFile(referencesPath, mapping.rows)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func listReferences(fileConfig *fileConfig, reference, memberID string) {
+	mapping, _ := loadReferenceMapping(fileConfig)
+	if len(reference) > 0 || len(memberID) > 0 {
+		mapping.rows = mapping.find(reference, memberID)
+	}
+
+	mapping.sort()
+	err := writeMemberReferencesToCsv(os.Stdout, mapping.rows)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func explainReference(fileConfig *fileConfig, membership *membership, rc *runConfig, reference string) {
+	activeMembers, err := membership.loadAndFilterTxns(fileConfig.getBankTxnsPath(), rc)
+	if err != nil {
+		panic(err)
+	}
+
+	txns := filterTxnsByReference(activeMembers.txns.all, reference)
+	if len(txns) == 0 {
+		fmt.Printf("No transactions with reference %v in %v.\n", normaliseReference(reference), fileConfig.getBankTxnsPath())
+		rule, ok := membership.references.match(normaliseReference(reference), rc.month)
+		if ok {
+			fmt.Printf("Reference %v is mapped by %v to %v.\n", normaliseReference(reference), rule, strings.Join(rule.memberIDs, "|"))
+		} else {
+			fmt.Printf("Reference %v is not mapped.\n", normaliseReference(reference))
+		}
+
+		return
+	}
+
+	err = writeTracesToCsv(os.Stdout, membership.explainTxns(txns, activeMembers, rc))
+	if err != nil {
+		panic(err)
+	}
+}
+
+func main() {
+	var (
+		currentYyyyMm       = kingpin.Flag("currentYyyyMm", "override date string (for file organisation)").Default(time.Now().UTC().Format("200601")).String()
+		bankFormat          = kingpin.Flag("bankFormat", fmt.Sprintf("bank statement format (%v, one defined in bank_formats.yaml, or %v to detect from the header)", strings.Join(bankFormatNames(), ", "), autoBankFormat)).Default(autoBankFormat).String()
+		gracePeriodDays     = kingpin.Flag("gracePeriodDays", "days after cover runs out that a member is lapsing rather than lapsed").Default("30").Int()
+		referenceFirst      = kingpin.Flag("referenceFirst", "match references before checking amounts, to report wrong amounts from known members").Bool()
+		explain             = kingpin.Flag("explain", "write a trace of how each transaction was classified").Bool()
+		excludeOutOfPeriod  = kingpin.Flag("excludeOutOfPeriod", "leave transactions dated outside the month out of the run").Bool()
+		ignoreBalanceBreaks = kingpin.Flag("ignoreBalanceBreaks", "carry on, with a warning, when the statement's running balance doesn't add up").Bool()
+
+		runCommand = kingpin.Command("run", "work out the current members (the default command)").Default()
+		runBaseDir = runCommand.Arg("baseDir", "the base directory for the files").Required().String()
+
+		resolveCommand       = kingpin.Command("resolve", "resolve unmatched transactions")
+		resolveExportCommand = resolveCommand.Command("export", "write a decisions sheet for the month's unmatched transactions")
+		resolveExportBaseDir = resolveExportCommand.Arg("baseDir", "the base directory for the files").Required().String()
+		resolveImportCommand = resolveCommand.Command("import", "apply a filled in decisions sheet")
+		resolveImportBaseDir = resolveImportCommand.Arg("baseDir", "the base directory for the files").Required().String()
+
+		explainCommand = kingpin.Command("explain", "show how the month's transactions with a reference were classified")
+		explainRef     = explainCommand.Flag("reference", "the reference to explain").Required().String()
+		explainBaseDir = explainCommand.Arg("baseDir", "the base directory for the files").Required().String()
+
+		checkReferencesCommand = kingpin.Command("check-references", "report problems with the reference mapping")
+		checkMemberIDPattern   = checkReferencesCommand.Flag("memberIdPattern", "regular expression a well formed member ID matches").Default(`^[A-Z][0-9]{6}$`).String()
+		checkMaxReferences     = checkReferencesCommand.Flag("maxReferences", "most references a member ID can be mapped from before it's reported").Default("2").Int()
+		checkStaleMonths       = checkReferencesCommand.Flag("staleMonths", "months without a matching transaction before a reference is stale").Default("12").Int()
+		checkReferencesBaseDir = checkReferencesCommand.Arg("baseDir", "the base directory for the files").Required().String()
+
+		refCommand         = kingpin.Command("ref", "manage the reference mapping")
+		refAddCommand      = refCommand.Command("add", "map a new reference to member IDs")
+		refAddReference    = refAddCommand.Flag("reference", "the reference to add").Required().String()
+		refAddMemberIDs    = refAddCommand.Flag("memberIds", "the member IDs it pays for, pipe separated").Required().String()
+		refAddMatch        = refAddCommand.Flag("match", "how the reference is matched (literal, prefix, contains or regex)").Default(matchLiteral).String()
+		refAddFeeCategory  = refAddCommand.Flag("feeCategory", "the fee category the reference pays").String()
+		refAddValidFrom    = refAddCommand.Flag("validFrom", "the first day the mapping applies, blank for always").String()
+		refAddValidTo      = refAddCommand.Flag("validTo", "the last day the mapping applies, blank for always").String()
+		refAddBaseDir      = refAddCommand.Arg("baseDir", "the base directory for the files").Required().String()
+		refRemoveCommand   = refCommand.Command("remove", "remove a reference")
+		refRemoveReference = refRemoveCommand.Flag("reference", "the reference to remove").Required().String()
+		refRemoveMatch     = refRemoveCommand.Flag("match", "how the reference is matched").Default(matchLiteral).String()
+		refRemoveValidFrom = refRemoveCommand.Flag("validFrom", "the day the mapping to remove is valid from, when there's more than one").String()
+		refRemoveBaseDir   = refRemoveCommand.Arg("baseDir", "the base directory for the files").Required().String()
+		refMoveCommand     = refCommand.Command("move", "re-point a reference to different member IDs")
+		refMoveReference   = refMoveCommand.Flag("reference", "the reference to move").Required().String()
+		refMoveMatch       = refMoveCommand.Flag("match", "how the reference is matched").Default(matchLiteral).String()
+		refMoveMemberIDs   = refMoveCommand.Flag("memberIds", "the member IDs it now pays for, pipe separated").Required().String()
+		refMoveValidFrom   = refMoveCommand.Flag("validFrom", "the day the mapping to move is valid from, when there's more than one").String()
+		refMoveFrom        = refMoveCommand.Flag("from", "the first day the new member IDs apply, blank to re-point the whole mapping").String()
+		refMoveBaseDir     = refMoveCommand.Arg("baseDir", "the base directory for the files").Required().String()
+		refListCommand     = refCommand.Command("list", "list the reference mapping")
+		refListBaseDir     = refListCommand.Arg("baseDir", "the base directory for the files").Required().String()
+		refFindCommand     = refCommand.Command("find", "find references by reference text or member ID")
+		refFindReference   = refFindCommand.Flag("reference", "text in, or matched by, the reference").String()
+		refFindMemberID    = refFindCommand.Flag("memberId", "a member ID the reference maps to").String()
+		refFindBaseDir     = refFindCommand.Arg("baseDir", "the base directory for the files").Required().String()
+	)
+
+	command := kingpin.Parse()
+	baseDir := map[string]*string{
+		runCommand.FullCommand():             runBaseDir,
+		resolveExportCommand.FullCommand():   resolveExportBaseDir,
+		resolveImportCommand.FullCommand():   resolveImportBaseDir,
+		explainCommand.FullCommand():         explainBaseDir,
+		checkReferencesCommand.FullCommand(): checkReferencesBaseDir,
+		refAddCommand.FullCommand():          refAddBaseDir,
+		refRemoveCommand.FullCommand():       refRemoveBaseDir,
+		refMoveCommand.FullCommand():         refMoveBaseDir,
+		refListCommand.FullCommand():         refListBaseDir,
+		refFindCommand.FullCommand():         refFindBaseDir,
+	}[command]
+
+	folderDate, err := time.Parse("200601", *currentYyyyMm)
+	if err != nil {
+		panic(err)
+	}
+
+	fileConfig := fileConfig{
+		*baseDir,
+		folderDate.Format("200601"),
+		folderDate.AddDate(0, -1, 0).Format("200601"),
+	}
+
+	// The ref commands only need the reference mapping, and the members when
+	// a reference is pointed at them.
+	switch command {
+	case refAddCommand.FullCommand():
+		members := loadRefMembers(&fileConfig)
+		editReferences(&fileConfig, "Added", func(mapping *referenceMapping) (*MemberReference, error) {
+			return mapping.add(&MemberReference{*refAddReference, *refAddMemberIDs, *refAddMatch, *refAddFeeCategory, *refAddValidFrom, *refAddValidTo}, members)
+		})
+		return
+	case refRemoveCommand.FullCommand():
+		editReferences(&fileConfig, "Removed", func(mapping *referenceMapping) (*MemberReference, error) {
+			return mapping.remove(*refRemoveMatch, *refRemoveReference, *refRemoveValidFrom)
+		})
+		return
+	case refMoveCommand.FullCommand():
+		members := loadRefMembers(&fileConfig)
+		editReferences(&fileConfig, "Moved", func(mapping *referenceMapping) (*MemberReference, error) {
+			return mapping.move(*refMoveMatch, *refMoveReference, *refMoveValidFrom, *refMoveMemberIDs, *refMoveFrom, members)
+		})
+		return
+	case refListCommand.FullCommand():
+		listReferences(&fileConfig, "", "")
+		return
+	case refFindCommand.FullCommand():
+		listReferences(&fileConfig, *refFindReference, *refFindMemberID)
+		return
+	}
+
+	bankFormats, err := loadBankFormatsFromYaml(fileConfig.getSourcePath(DefaultBankFormatsPath))
+	if err != nil {
+		panic(err)
+	}
+
+	err = registerBankCsvMappings(bankFormats.CsvMappings)
+	if err != nil {
+		panic(err)
+	}
+
+	membership, err := newMembership(&fileConfig)
+	if err != nil {
+		panic(err)
+	}
+
+	membership.txnTypes, err = newTxnTypeMapping(bankFormats.TxnTypes)
+	if err != nil {
+		panic(err)
+	}
+
+	// These commands print CSV, so anything else goes to stderr to keep it
+	// clean when it's redirected to a file.
+	var logOut io.Writer = os.Stdout
+	if command == checkReferencesCommand.FullCommand() || command == explainCommand.FullCommand() {
+		logOut = os.Stderr
+	}
+
+	fmt.Fprintf(logOut, "Loaded %v references.\n", membership.references.size())
+	fmt.Fprintf(logOut, "Loaded %v members details.\n", len(membership.members))
+	fmt.Fprintf(logOut, "Loaded %v new members details.\n", len(membership.newMembers))
+	fmt.Fprintf(logOut, "Loaded %v fee schedules.\n", len(membership.feeSchedules))
+	fmt.Fprintf(logOut, "Loaded %v ledger entries.\n", len(membership.ledger.entries))
+
+	fmt.Fprintf(logOut, "Loaded %v ignore rules.\n", len(membership.ignoreList.rules))
+
+	runConfig := runConfig{
+		folderDate,
+		*bankFormat,
+		time.Duration(*gracePeriodDays) * 24 * time.Hour,
+		*referenceFirst,
+		*explain,
+		*ignoreBalanceBreaks,
+		*excludeOutOfPeriod,
+	}
+
+	switch command {
+	case runCommand.FullCommand():
+		runMembership(&fileConfig, membership, &runConfig)
+	case resolveExportCommand.FullCommand():
+		exportDecisions(&fileConfig, membership, &runConfig)
+	case resolveImportCommand.FullCommand():
+		importDecisions(&fileConfig, membership)
+	case checkReferencesCommand.FullCommand():
+		memberIDPattern, err := regexp.Compile(*checkMemberIDPattern)
+		if err != nil {
+			panic(err)
+		}
+
+		checkReferences(membership, &referenceCheck{memberIDPattern, *checkMaxReferences, *checkStaleMonths, folderDate})
+	case explainCommand.FullCommand():
+		explainReference(&fileConfig, membership, &runConfig, *explainRef)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// A statement that runs into the previous month shouldn't make a member's
+// monthly payments look like duplicates, even when out-of-period rows are kept.
+func TestDuplicatePaymentsAreLimitedToTheMonth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "duplicates")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	statementPath := filepath.Join(dir, DefaultBankTxnsPath)
+	err = ioutil.WriteFile(statementPath, []byte(`Date,Type,Description,Paid Out,Paid In,Balance
+05-Jan-18,CR,JOE BLOGGS, ,18.5,100.00
+05-Jan-18,CR,ANN OTHER, ,18.5,118.50
+05-Feb-18,CR,JOE BLOGGS, ,18.5,137.00
+05-Feb-18,CR,ANN OTHER, ,18.5,155.50
+06-Feb-18,CR,ANN OTHER, ,18.5,174.00
+`), 0644)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	refs := newReferenceMatcher()
+	refs.add(matchLiteral, "JOE BLOGGS", []string{"A1"})
+	refs.add(matchLiteral, "ANN OTHER", []string{"A2"})
+	m := &membership{
+		references: refs,
+		members:    map[string]*Member{"A1": {MemberID: "A1"}, "A2": {MemberID: "A2"}},
+		feeSchedules: []*feeSchedule{
+			{
+				effectiveFrom:  time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC),
+				correctAmounts: []decimal.Decimal{decimal.New(185, -1)},
+			},
+		},
+		ledger:     &ledger{},
+		ignoreList: &ignoreList{},
+	}
+
+	for _, excludeOutOfPeriod := range []bool{false, true} {
+		rc := &runConfig{month: time.Date(2018, 2, 1, 0, 0, 0, 0, time.UTC), bankFormat: autoBankFormat, excludeOutOfPeriod: excludeOutOfPeriod}
+		m.ledger = &ledger{}
+		am, err := m.loadAndFilterTxns(statementPath, rc)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		if len(am.txns.duplicates) != 1 {
+			t.Fatalf("%v != 1 (excludeOutOfPeriod %v)", len(am.txns.duplicates), excludeOutOfPeriod)
+		}
+
+		duplicate := am.txns.duplicates[0]
+		if duplicate.memberID != "A2" || len(duplicate.txns) != 2 {
+			t.Fatalf("%v != A2 with 2 payments: %v", duplicate.memberID, duplicate.txns)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	minNameConfidence    = 0.5
+	minSurnameSimilarity = 0.8
+)
+
+var nameTitles = map[string]bool{
+	"MR":   true,
+	"MRS":  true,
+	"MS":   true,
+	"MISS": true,
+	"DR":   true,
+}
+
+// Accented capitals and what they're folded to, as banks often drop accents
+// from the payer's name.
+var accentFolds = newAccentFolds(map[string]string{
+	"A":  "ÀÁÂÃÄÅĀĂĄ",
+	"AE": "Æ",
+	"C":  "ÇĆĈĊČ",
+	"D":  "ĎĐ",
+	"E":  "ÈÉÊËĒĔĖĘĚ",
+	"G":  "ĜĞĠĢ",
+	"H":  "ĤĦ",
+	"I":  "ÌÍÎÏĨĪĬĮİ",
+	"J":  "Ĵ",
+	"K":  "Ķ",
+	"L":  "ĹĻĽĿŁ",
+	"N":  "ÑŃŅŇ",
+	"O":  "ÒÓÔÕÖØŌŎŐ",
+	"OE": "Œ",
+	"R":  "ŔŖŘ",
+	"S":  "ŚŜŞŠ",
+	"SS": "ß",
+	"T":  "ŢŤŦ",
+	"TH": "Þ",
+	"U":  "ÙÚÛÜŨŪŬŮŰŲ",
+	"W":  "Ŵ",
+	"Y":  "ÝŶŸ",
+	"Z":  "ŹŻŽ",
+})
+
+func newAccentFolds(folds map[string]string) map[rune]string {
+	runes := make(map[rune]string)
+	for folded, accented := range folds {
+		for _, r := range accented {
+			runes[r] = folded
+		}
+	}
+
+	return runes
+}
+
+func foldAccents(s string) string {
+	var folded strings.Builder
+	for _, r := range strings.ToUpper(s) {
+		if replacement, ok := accentFolds[r]; ok {
+			folded.WriteString(replacement)
+		} else {
+			folded.WriteRune(r)
+		}
+	}
+
+	return folded.String()
+}
+
+func nameWords(name string) []string {
+	return strings.FieldsFunc(foldAccents(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+type nameSuggestion struct {
+	reference  string
+	txn        *bankTxn
+	member     *Member
+	confidence float64
+}
+
+// Where the surname is in words, and how sure we are it's the surname. A
+// surname of several words can also appear run together, so O'Brien matches
+// OBRIEN as well as O BRIEN.
+func findSurname(words []string, surname []string) (start, end int, score float64) {
+	joined := strings.Join(surname, "")
+	for i := range words {
+		if i+len(surname) <= len(words) && strings.Join(words[i:i+len(surname)], " ") == strings.Join(surname, " ") {
+			return i, i + len(surname), 1
+		}
+
+		if words[i] == joined {
+			return i, i + 1, 1
+		}
+	}
+
+	start = -1
+	if len(joined) < 4 {
+		return start, start, 0
+	}
+
+	for i, word := range words {
+		similarity := referenceSimilarity(word, joined)
+		if similarity >= minSurnameSimilarity && similarity > score {
+			start, end, score = i, i+1, similarity
+		}
+	}
+
+	return start, end, score
+}
+
+/*
+The words either side of the surname are checked against the forenames, so
+both J BLOGGS and BLOGGS JA match Joe Alan Bloggs. A first forename in full is
+the strongest match, then all the initials, then the first initial alone.
+Titles are skipped. A short word that isn't one of the member's initials most
+likely belongs to someone else with the same surname, and any other word that
+can't be explained knocks the confidence down a little.
+*/
+func nameConfidence(reference string, member *Member) float64 {
+	words := nameWords(reference)
+	surname := nameWords(member.Surname)
+	forenames := nameWords(member.Forenames)
+	if len(surname) == 0 || len(words) == 0 {
+		return 0
+	}
+
+	start, end, confidence := findSurname(words, surname)
+	if start < 0 {
+		return 0
+	}
+
+	initials := ""
+	for _, forename := range forenames {
+		initials += string([]rune(forename)[0])
+	}
+
+	rest := append(append([]string{}, words[:start]...), words[end:]...)
+	forenameScore := 0.6
+	for _, word := range rest {
+		score := 0.0
+		switch {
+		case nameTitles[word]:
+			continue
+		case len(forenames) > 0 && word == forenames[0]:
+			score = 1
+		case len(initials) > 1 && word == initials:
+			score = 0.9
+		case len(forenames) > 0 && len(word) >= 3 && strings.HasPrefix(forenames[0], word):
+			score = 0.85
+		case len(word) <= len(initials) && strings.HasPrefix(initials, word):
+			score = 0.8
+		case containsWord(forenames, word):
+			score = 0.7
+		case len(word) <= 2:
+			return confidence * 0.3
+		default:
+			confidence *= 0.9
+		}
+
+		if score > forenameScore {
+			forenameScore = score
+		}
+	}
+
+	return confidence * forenameScore
+}
+
+func containsWord(words []string, word string) bool {
+	for _, w := range words {
+		if w == word {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+Suggests which member each unmatched reference belongs to from their name.
+When several members fit equally well, each is suggested with the confidence
+shared between them, so a surname alone rarely makes the cut when two members
+share it.
+*/
+func suggestMemberReferences(txns []*bankTxn, members map[string]*Member) (suggestions []*nameSuggestion) {
+	seen := make(map[string]bool)
+	for _, txn := range txns {
+		reference := txn.reference()
+		if len(reference) == 0 || seen[reference] {
+			continue
+		}
+
+		seen[reference] = true
+		best := []*nameSuggestion{}
+		for _, member := range members {
+			confidence := nameConfidence(reference, member)
+			if confidence == 0 || (len(best) > 0 && confidence < best[0].confidence) {
+				continue
+			}
+
+			if len(best) > 0 && confidence > best[0].confidence {
+				best = best[:0]
+			}
+
+			best = append(best, &nameSuggestion{reference, txn, member, confidence})
+		}
+
+		for _, suggestion := range best {
+			suggestion.confidence /= float64(len(best))
+			if suggestion.confidence >= minNameConfidence {
+				suggestions = append(suggestions, suggestion)
+			}
+		}
+	}
+
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].reference != suggestions[j].reference {
+			return suggestions[i].reference < suggestions[j].reference
+		}
+
+		return suggestions[i].member.MemberID < suggestions[j].member.MemberID
+	})
+
+	return suggestions
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestFoldAccents(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"José Núñez", "JOSE NUNEZ"},
+		{"Zoë Åberg", "ZOE ABERG"},
+		{"Łukasz Straße", "LUKASZ STRASSE"},
+		{"Plain", "PLAIN"},
+	}
+
+	for _, test := range tests {
+		if actual := foldAccents(test.name); actual != test.expected {
+			t.Fatalf("%v != %v", actual, test.expected)
+		}
+	}
+}
+
+func TestSuggestMemberReferences(t *testing.T) {
+	members := map[string]*Member{
+		"A1": {MemberID: "A1", Forenames: "Joe Alan", Surname: "Bloggs"},
+		"A2": {MemberID: "A2", Forenames: "Kate", Surname: "Bloggs"},
+		"A3": {MemberID: "A3", Forenames: "José", Surname: "Núñez"},
+		"A4": {MemberID: "A4", Forenames: "Mary", Surname: "O'Brien"},
+	}
+
+	tests := []struct {
+		description string
+		memberID    string
+		confidence  string
+	}{
+		{"FP J BLOGGS", "A1", "0.80"},
+		{"BLOGGS JA", "A1", "0.90"},
+		{"MRS K BLOGGS", "A2", "0.80"},
+		{"JOSE NUNEZ SUBS", "A3", "0.90"},
+		{"OBRIEN M", "A4", "0.80"},
+		{"M OBRIAN", "A4", "0.67"},
+		{"BLOGGS", "", ""},
+		{"X BLOGGS", "", ""},
+		{"SHOP", "", ""},
+	}
+
+	for _, test := range tests {
+		suggestions := suggestMemberReferences([]*bankTxn{{description: test.description, amount: decimal.New(30, 0)}}, members)
+		if len(test.memberID) == 0 {
+			if len(suggestions) > 0 {
+				t.Fatalf("%v unexpectedly suggested %v", test.description, suggestions[0].member.MemberID)
+			}
+
+			continue
+		}
+
+		if len(suggestions) != 1 {
+			t.Fatalf("%v: expected 1 suggestion, got %v", test.description, len(suggestions))
+		}
+
+		if suggestions[0].member.MemberID != test.memberID {
+			t.Fatalf("%v: %v != %v", test.description, suggestions[0].member.MemberID, test.memberID)
+		}
+
+		if confidence := decimal.NewFromFloat(suggestions[0].confidence).StringFixed(2); confidence != test.confidence {
+			t.Fatalf("%v: %v != %v", test.description, confidence, test.confidence)
+		}
+	}
+}
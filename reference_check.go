@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	problemDuplicateReference = "duplicate reference"
+	problemNoMemberIDs        = "no member IDs"
+	problemMalformedMemberID  = "malformed member ID"
+	problemUnknownMember      = "unknown member"
+	problemManyReferences     = "many references"
+	problemStaleReference     = "stale reference"
+	problemUnusedReference    = "unused reference"
+)
+
+type referenceProblem struct {
+	rule     *referenceRule
+	memberID string
+	problem  string
+	detail   string
+}
+
+// Unused references are only worth a look, as they may just be new.
+func (p *referenceProblem) failing() bool {
+	return p.problem != problemUnusedReference
+}
+
+type referenceCheck struct {
+	memberIDPattern *regexp.Regexp
+	maxReferences   int
+	staleMonths     int
+	month           time.Time
+}
+
+/*
+Looks over the reference mapping for problems that otherwise only show up
+when a payment happens to arrive. A reference is stale when the ledger
+doesn't have a transaction it matched in the staleMonths before the start of
+month. A reference that has never matched anything is only stale once it's
+been valid for longer than that; before then, or when there's no ValidFrom to
+tell, it's reported as unused. Mappings that ended before month are history,
+so they're neither stale nor counted towards a member's references.
+*/
+func (rc *referenceCheck) checkReferences(references *referenceMatcher, members map[string]*Member, entries []*ledgerEntry) (problems []*referenceProblem) {
+	for _, rule := range references.duplicates {
+		problems = append(problems, &referenceProblem{rule, "", problemDuplicateReference, "only the first mapping of the reference is used"})
+	}
+
+	// The ledger only records a rule's kind and pattern, so a match is
+	// credited to the row for that reference valid on the transaction's date.
+	rulesByString := make(map[string][]*referenceRule)
+	for _, rule := range references.allRules() {
+		rulesByString[rule.String()] = append(rulesByString[rule.String()], rule)
+	}
+
+	lastMatched := make(map[*referenceRule]time.Time)
+	for _, entry := range entries {
+		for _, rule := range rulesByString[entry.matchedRule] {
+			if !rule.activeOn(entry.txn.date) {
+				continue
+			}
+
+			if entry.txn.date.After(lastMatched[rule]) {
+				lastMatched[rule] = entry.txn.date
+			}
+
+			break
+		}
+	}
+
+	staleBefore := rc.month.AddDate(0, -rc.staleMonths, 0)
+	memberReferences := make(map[string][]*referenceRule)
+	for _, rule := range references.allRules() {
+		ended := !rule.validTo.IsZero() && rule.validTo.Before(rc.month)
+		memberIDs := []string{}
+		for _, memberID := range rule.memberIDs {
+			memberID = strings.TrimSpace(memberID)
+			if len(memberID) > 0 {
+				memberIDs = append(memberIDs, memberID)
+			}
+		}
+
+		if len(memberIDs) == 0 {
+			problems = append(problems, &referenceProblem{rule, "", problemNoMemberIDs, "the MemberIds cell is empty"})
+		}
+
+		for _, memberID := range memberIDs {
+			if !rc.memberIDPattern.MatchString(memberID) {
+				problems = append(problems, &referenceProblem{rule, memberID, problemMalformedMemberID, fmt.Sprintf("doesn't look like %v", rc.memberIDPattern)})
+			} else if _, ok := members[memberID]; !ok {
+				problems = append(problems, &referenceProblem{rule, memberID, problemUnknownMember, "not in the membership details"})
+			}
+
+			if !ended {
+				memberReferences[memberID] = append(memberReferences[memberID], rule)
+			}
+		}
+
+		matched, ok := lastMatched[rule]
+		if ended {
+			continue
+		} else if !ok && !rule.validFrom.IsZero() && rule.validFrom.Before(staleBefore) {
+			problems = append(problems, &referenceProblem{rule, "", problemStaleReference, fmt.Sprintf("never matched a transaction in the ledger since %v", rule.validFrom.Format(ledgerDateFormat))})
+		} else if !ok {
+			problems = append(problems, &referenceProblem{rule, "", problemUnusedReference, "never matched a transaction in the ledger"})
+		} else if matched.Before(staleBefore) {
+			problems = append(problems, &referenceProblem{rule, "", problemStaleReference, fmt.Sprintf("last matched on %v", matched.Format(ledgerDateFormat))})
+		}
+	}
+
+	for _, rule := range references.allRules() {
+		for _, memberID := range rule.memberIDs {
+			memberID = strings.TrimSpace(memberID)
+			rules := memberReferences[memberID]
+			if len(rules) <= rc.maxReferences || rules[0] != rule {
+				continue
+			}
+
+			patterns := []string{}
+			for _, other := range rules {
+				patterns = append(patterns, other.String())
+			}
+
+			problems = append(problems, &referenceProblem{rule, memberID, problemManyReferences, fmt.Sprintf("mapped from %v references: %v", len(rules), strings.Join(patterns, ", "))})
+		}
+	}
+
+	return problems
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestCheckReferences(t *testing.T) {
+	refs := newReferenceMatcher()
+	refs.add(matchLiteral, "GOOD", []string{"A123456"})
+	refs.add(matchLiteral, "GOOD", []string{"A123456"})
+	refs.add(matchLiteral, "EMPTY", []string{""})
+	refs.add(matchLiteral, "MALFORMED", []string{"123456"})
+	refs.add(matchLiteral, "UNKNOWN", []string{"A999999"})
+	refs.add(matchLiteral, "OLD", []string{"A234567"})
+	refs.add(matchLiteral, "BUSY 1", []string{"A345678"})
+	refs.add(matchPrefix, "BUSY 2", []string{"A345678"})
+	refs.add(matchLiteral, "NEW", []string{"A456789"})
+	refs.addValid(matchLiteral, "RECENT", []string{"A567890"}, time.Date(2017, 12, 1, 0, 0, 0, 0, time.UTC), time.Time{})
+	refs.addValid(matchLiteral, "NEVER", []string{"A678901"}, time.Date(2016, 6, 1, 0, 0, 0, 0, time.UTC), time.Time{})
+
+	members := map[string]*Member{
+		"A123456": {MemberID: "A123456"},
+		"A234567": {MemberID: "A234567"},
+		"A345678": {MemberID: "A345678"},
+		"A456789": {MemberID: "A456789"},
+		"A567890": {MemberID: "A567890"},
+		"A678901": {MemberID: "A678901"},
+	}
+
+	entries := []*ledgerEntry{
+		newTestLedgerEntry(2018, time.January, 16, "GOOD", decimal.New(185, -1), "A123456"),
+		newTestLedgerEntry(2018, time.January, 16, "EMPTY", decimal.New(185, -1), ""),
+		newTestLedgerEntry(2018, time.January, 16, "MALFORMED", decimal.New(185, -1), "123456"),
+		newTestLedgerEntry(2018, time.January, 16, "UNKNOWN", decimal.New(185, -1), "A999999"),
+		newTestLedgerEntry(2016, time.January, 16, "OLD", decimal.New(185, -1), "A234567"),
+		newTestLedgerEntry(2018, time.January, 16, "BUSY 1", decimal.New(185, -1), "A345678"),
+		newTestLedgerEntry(2018, time.January, 16, "BUSY 2", decimal.New(185, -1), "A345678"),
+	}
+
+	for _, entry := range entries {
+		rule, _ := refs.matchTxn(entry.txn)
+		entry.matchedRule = rule.String()
+	}
+
+	check := &referenceCheck{regexp.MustCompile(`^[A-Z][0-9]{6}$`), 1, 12, time.Date(2018, 2, 1, 0, 0, 0, 0, time.UTC)}
+	problems := check.checkReferences(refs, members, entries)
+
+	expected := map[string][]string{
+		problemDuplicateReference: {"literal:GOOD"},
+		problemNoMemberIDs:        {"literal:EMPTY"},
+		problemMalformedMemberID:  {"literal:MALFORMED"},
+		problemUnknownMember:      {"literal:UNKNOWN"},
+		problemStaleReference:     {"literal:NEVER", "literal:OLD"},
+		problemUnusedReference:    {"literal:NEW", "literal:RECENT"},
+		problemManyReferences:     {"literal:BUSY 1"},
+	}
+
+	actual := make(map[string][]string)
+	for _, problem := range problems {
+		actual[problem.problem] = append(actual[problem.problem], problem.rule.String())
+		if problem.failing() == (problem.problem == problemUnusedReference) {
+			t.Fatalf("%v for %v has the wrong failing %v", problem.problem, problem.rule, problem.failing())
+		}
+	}
+
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("%v != %v", actual, expected)
+	}
+}
+
+// A reference remapped from one date shares its pattern with the old row, but
+// the old row's payments don't count as the new row being used.
+func TestCheckReferencesWithDatedRows(t *testing.T) {
+	refs := newReferenceMatcher()
+	refs.addValid(matchLiteral, "SMITH", []string{"A123456"}, time.Time{}, time.Date(2017, 11, 30, 0, 0, 0, 0, time.UTC))
+	refs.addValid(matchLiteral, "SMITH", []string{"A234567"}, time.Date(2017, 12, 1, 0, 0, 0, 0, time.UTC), time.Time{})
+	members := map[string]*Member{
+		"A123456": {MemberID: "A123456"},
+		"A234567": {MemberID: "A234567"},
+	}
+
+	entry := newTestLedgerEntry(2017, time.November, 16, "SMITH", decimal.New(185, -1), "A123456")
+	rule, _ := refs.matchTxn(entry.txn)
+	entry.matchedRule = rule.String()
+
+	check := &referenceCheck{regexp.MustCompile(`^[A-Z][0-9]{6}$`), 1, 12, time.Date(2018, 2, 1, 0, 0, 0, 0, time.UTC)}
+	problems := check.checkReferences(refs, members, []*ledgerEntry{entry})
+	if len(problems) != 1 {
+		t.Fatalf("%v != 1", len(problems))
+	}
+
+	if problems[0].problem != problemUnusedReference || !reflect.DeepEqual(problems[0].rule.memberIDs, []string{"A234567"}) {
+		t.Fatalf("%v for %v != %v for %v", problems[0].problem, problems[0].rule.memberIDs, problemUnusedReference, []string{"A234567"})
+	}
+}
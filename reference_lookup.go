@@ -1,24 +1,247 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
 )
 
+// Banks prefix the payer's reference with how the money arrived.
+var bankReferencePrefixes = map[string]bool{
+	"FP":   true,
+	"FPI":  true,
+	"SO":   true,
+	"STO":  true,
+	"BGC":  true,
+	"BACS": true,
+}
+
+const (
+	maxReferenceSuggestions = 3
+	minReferenceSimilarity  = 0.6
+)
+
+func normaliseReference(reference string) string {
+	words := strings.FieldsFunc(strings.ToUpper(reference), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for len(words) > 1 && bankReferencePrefixes[words[0]] {
+		words = words[1:]
+	}
+
+	return strings.Join(words, " ")
+}
+
+func (t *bankTxn) reference() string {
+	return normaliseReference(t.description)
+}
+
+// Rule kinds, in order of precedence.
+const (
+	matchLiteral  = "literal"
+	matchPrefix   = "prefix"
+	matchContains = "contains"
+	matchRegex    = "regex"
+)
+
+var matchKinds = []string{matchLiteral, matchPrefix, matchContains, matchRegex}
+
+var errDuplicateReference = errors.New("Duplicate reference")
+
+type referenceRule struct {
+	kind        string
+	pattern     string
+	regex       *regexp.Regexp
+	memberIDs   []string
+	feeCategory string
+	validFrom   time.Time
+	validTo     time.Time
+}
+
+func (r *referenceRule) String() string {
+	return r.kind + ":" + r.pattern
+}
+
+// A zero validFrom or validTo leaves the rule open ended. validTo is the last
+// day the rule applies.
+func (r *referenceRule) activeOn(date time.Time) bool {
+	return (r.validFrom.IsZero() || !date.Before(r.validFrom)) && (r.validTo.IsZero() || !date.After(r.validTo))
+}
+
+func (r *referenceRule) overlaps(other *referenceRule) bool {
+	if !r.validTo.IsZero() && !other.validFrom.IsZero() && r.validTo.Before(other.validFrom) {
+		return false
+	}
+
+	return other.validTo.IsZero() || r.validFrom.IsZero() || !other.validTo.Before(r.validFrom)
+}
+
+func (r *referenceRule) matches(reference string) bool {
+	switch r.kind {
+	case matchLiteral:
+		return reference == r.pattern
+	case matchPrefix:
+		return strings.HasPrefix(reference, r.pattern)
+	case matchContains:
+		return strings.Contains(reference, r.pattern)
+	case matchRegex:
+		return r.regex.MatchString(reference)
+	}
+
+	return false
+}
+
+/*
+Literal references are looked up directly. Otherwise prefix rules are tried,
+then contains rules, then regular expressions, each in the order they appear
+in the mapping file, and the first match wins. Rules are compared against the
+normalised transaction reference, and only rules valid on the transaction's
+date are used. The same reference can be mapped more than once as long as the
+periods the mappings are valid for don't overlap.
+*/
+type referenceMatcher struct {
+	literals   map[string][]*referenceRule
+	rules      map[string][]*referenceRule
+	duplicates []*referenceRule
+}
+
+func newReferenceMatcher() *referenceMatcher {
+	return &referenceMatcher{map[string][]*referenceRule{}, map[string][]*referenceRule{}, nil}
+}
+
+func (rm *referenceMatcher) add(kind, pattern string, memberIDs []string) (*referenceRule, error) {
+	return rm.addValid(kind, pattern, memberIDs, time.Time{}, time.Time{})
+}
+
+func (rm *referenceMatcher) addValid(kind, pattern string, memberIDs []string, validFrom, validTo time.Time) (*referenceRule, error) {
+	if len(kind) == 0 {
+		kind = matchLiteral
+	}
+
+	if !validFrom.IsZero() && !validTo.IsZero() && validTo.Before(validFrom) {
+		return nil, fmt.Errorf("Reference %v is valid to %v, before it's valid from %v", pattern, validTo.Format(ledgerDateFormat), validFrom.Format(ledgerDateFormat))
+	}
+
+	rule := &referenceRule{kind: kind, memberIDs: memberIDs, validFrom: validFrom, validTo: validTo}
+	switch kind {
+	case matchLiteral:
+		rule.pattern = normaliseReference(pattern)
+		for _, existing := range rm.literals[rule.pattern] {
+			if existing.overlaps(rule) {
+				rm.duplicates = append(rm.duplicates, rule)
+				return nil, errDuplicateReference
+			}
+		}
+
+		rm.literals[rule.pattern] = append(rm.literals[rule.pattern], rule)
+		return rule, nil
+	case matchPrefix, matchContains:
+		rule.pattern = normaliseReference(pattern)
+	case matchRegex:
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		rule.pattern = pattern
+		rule.regex = regex
+	default:
+		return nil, fmt.Errorf("Unknown match kind %v (expected one of %v)", kind, matchKinds)
+	}
+
+	for _, existing := range rm.rules[kind] {
+		if existing.pattern == rule.pattern && existing.overlaps(rule) {
+			rm.duplicates = append(rm.duplicates, rule)
+			return nil, errDuplicateReference
+		}
+	}
+
+	rm.rules[kind] = append(rm.rules[kind], rule)
+
+	return rule, nil
+}
+
+func (rm *referenceMatcher) match(reference string, date time.Time) (*referenceRule, bool) {
+	for _, rule := range rm.literals[reference] {
+		if rule.activeOn(date) {
+			return rule, true
+		}
+	}
+
+	for _, kind := range matchKinds[1:] {
+		for _, rule := range rm.rules[kind] {
+			if rule.activeOn(date) && rule.matches(reference) {
+				return rule, true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+func (rm *referenceMatcher) matchTxn(txn *bankTxn) (*referenceRule, bool) {
+	return rm.match(txn.reference(), txn.date)
+}
+
+// Literals in alphabetical order, then the other rules in precedence order.
+func (rm *referenceMatcher) allRules() (rules []*referenceRule) {
+	literals := []string{}
+	for pattern := range rm.literals {
+		literals = append(literals, pattern)
+	}
+
+	sort.Strings(literals)
+	for _, pattern := range literals {
+		rules = append(rules, rm.literals[pattern]...)
+	}
+
+	for _, kind := range matchKinds[1:] {
+		rules = append(rules, rm.rules[kind]...)
+	}
+
+	return rules
+}
+
+// The first rule listing each member ID, with literals before other rules.
+func (rm *referenceMatcher) memberRules() map[string]*referenceRule {
+	memberRules := make(map[string]*referenceRule)
+	for _, rule := range rm.allRules() {
+		for _, memberID := range rule.memberIDs {
+			if _, ok := memberRules[memberID]; !ok {
+				memberRules[memberID] = rule
+			}
+		}
+	}
+
+	return memberRules
+}
+
+func (rm *referenceMatcher) size() (size int) {
+	for _, rules := range rm.literals {
+		size += len(rules)
+	}
+
+	for _, rules := range rm.rules {
+		size += len(rules)
+	}
+
+	return size
+}
+
 /*
 This file used to include a struct with additional boilerplate. Keeping
 this here as I don't currently have a better place for it.
 */
-func identifyMembers(txns []*bankTxn, references map[string][]string) (memberIds []string, unmatchedTxns []*bankTxn) {
+func identifyMembers(txns []*bankTxn, references *referenceMatcher) (memberIds []string, unmatchedTxns []*bankTxn) {
 	memberSet := make(map[string]bool)
 	for _, txn := range txns {
-		memberIDs, ok := references[txn.description]
+		rule, ok := references.matchTxn(txn)
 		if ok {
-			for _, memberID := range memberIDs {
-				_, ok := memberSet[memberID]
-				if ok {
-					fmt.Fprintf(os.Stderr, "Duplicate member ID: %v\n", memberID)
-				}
+			for _, memberID := range rule.memberIDs {
 				memberSet[memberID] = true
 			}
 		} else {
@@ -33,5 +256,136 @@ func identifyMembers(txns []*bankTxn, references map[string][]string) (memberIds
 		i++
 	}
 
+	sort.Strings(memberIds)
+
 	return memberIds, unmatchedTxns
 }
+
+type duplicatePayment struct {
+	memberID string
+	txns     []*bankTxn
+	rules    []*referenceRule
+}
+
+// Members reached by more than one of the transactions, with every
+// transaction that reached them and the rules that mapped it.
+func identifyDuplicatePayments(txns []*bankTxn, references *referenceMatcher) (duplicates []*duplicatePayment) {
+	payments := make(map[string]*duplicatePayment)
+	for _, txn := range txns {
+		rule, ok := references.matchTxn(txn)
+		if !ok {
+			continue
+		}
+
+		for _, memberID := range rule.memberIDs {
+			payment, ok := payments[memberID]
+			if !ok {
+				payment = &duplicatePayment{memberID: memberID}
+				payments[memberID] = payment
+			}
+
+			payment.txns = append(payment.txns, txn)
+			payment.rules = append(payment.rules, rule)
+		}
+	}
+
+	for _, payment := range payments {
+		if len(payment.txns) > 1 {
+			duplicates = append(duplicates, payment)
+		}
+	}
+
+	sort.Slice(duplicates, func(i, j int) bool {
+		return duplicates[i].memberID < duplicates[j].memberID
+	})
+
+	return duplicates
+}
+
+func splitKnownReferences(txns []*bankTxn, references *referenceMatcher) (knownTxns []*bankTxn, unknownTxns []*bankTxn) {
+	for _, txn := range txns {
+		if _, ok := references.matchTxn(txn); ok {
+			knownTxns = append(knownTxns, txn)
+		} else {
+			unknownTxns = append(unknownTxns, txn)
+		}
+	}
+
+	return knownTxns, unknownTxns
+}
+
+type referenceSuggestion struct {
+	reference string
+	score     float64
+}
+
+func levenshteinDistance(a, b []rune) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			current[j] = previous[j] + 1
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+
+			if previous[j-1]+cost < current[j] {
+				current[j] = previous[j-1] + cost
+			}
+		}
+
+		previous, current = current, previous
+	}
+
+	return previous[len(b)]
+}
+
+// Scores run from 0 (nothing in common) to 1 (identical once normalised).
+func referenceSimilarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+
+	if longest == 0 {
+		return 1
+	}
+
+	return 1 - float64(levenshteinDistance(ra, rb))/float64(longest)
+}
+
+// Only literal references are suggested, as rules can't be scored sensibly.
+func suggestReferences(txn *bankTxn, references *referenceMatcher) (suggestions []*referenceSuggestion) {
+	reference := txn.reference()
+	for candidate := range references.literals {
+		score := referenceSimilarity(reference, candidate)
+		if score >= minReferenceSimilarity {
+			suggestions = append(suggestions, &referenceSuggestion{candidate, score})
+		}
+	}
+
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].score != suggestions[j].score {
+			return suggestions[i].score > suggestions[j].score
+		}
+
+		return suggestions[i].reference < suggestions[j].reference
+	})
+
+	if len(suggestions) > maxReferenceSuggestions {
+		suggestions = suggestions[:maxReferenceSuggestions]
+	}
+
+	return suggestions
+}
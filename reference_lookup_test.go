@@ -2,25 +2,25 @@ package main
 
 import (
 	"testing"
+	"time"
 
 	"github.com/shopspring/decimal"
 )
 
 func TestIdentifyMembers(t *testing.T) {
 	txns := []*bankTxn{
-		{"JOE BLOGGS", decimal.New(15, 1)},
-		{"NO MATCH", decimal.New(30, 1)},
-		{"JOE BLOGGS", decimal.New(15, 1)},
-	}
-	refs := map[string][]string{
-		"JOE BLOGGS": {"A123456", "A789012"},
+		{description: "JOE BLOGGS", amount: decimal.New(15, 1)},
+		{description: "NO MATCH", amount: decimal.New(30, 1)},
+		{description: "JOE BLOGGS", amount: decimal.New(15, 1)},
 	}
+	refs := newReferenceMatcher()
+	refs.add(matchLiteral, "JOE BLOGGS", []string{"A123456", "A789012"})
 	expectedMemberIds := []string{
 		"A123456",
 		"A789012",
 	}
 	expectedUnmatchedTxns := []*bankTxn{
-		{"NO MATCH", decimal.New(30, 1)},
+		{description: "NO MATCH", amount: decimal.New(30, 1)},
 	}
 
 	actualMemberIds, actualUnmatchedTxns := identifyMembers(txns, refs)
@@ -57,3 +57,188 @@ func TestIdentifyMembers(t *testing.T) {
 		}
 	}
 }
+
+func TestNormaliseReference(t *testing.T) {
+	testCases := []struct {
+		reference string
+		expected  string
+	}{
+		{"  Joe   Bloggs ", "JOE BLOGGS"},
+		{"FP J.BLOGGS-SUBS", "J BLOGGS SUBS"},
+		{"SO FP Joe Bloggs", "JOE BLOGGS"},
+		{"SO", "SO"},
+		{"SOLOMON", "SOLOMON"},
+	}
+
+	for _, testCase := range testCases {
+		actual := normaliseReference(testCase.reference)
+		if actual != testCase.expected {
+			t.Fatalf("%q normalised to %q, expected %q", testCase.reference, actual, testCase.expected)
+		}
+	}
+}
+
+func TestSuggestReferences(t *testing.T) {
+	refs := newReferenceMatcher()
+	refs.add(matchLiteral, "JOE BLOGGS", []string{"A123456"})
+	refs.add(matchLiteral, "JANE BLOGGS", []string{"A789012"})
+	refs.add(matchLiteral, "DIVE SHOP", []string{"A345678"})
+	refs.add(matchPrefix, "JOE", []string{"A901234"})
+	txn := &bankTxn{description: "FP JOE BLOGS", amount: decimal.New(185, -1)}
+	expectedReferences := []string{"JOE BLOGGS", "JANE BLOGGS"}
+
+	actualSuggestions := suggestReferences(txn, refs)
+
+	if len(actualSuggestions) != len(expectedReferences) {
+		t.Fatalf(
+			"Suggestion counts are not the same (%v, %v)",
+			len(actualSuggestions),
+			len(expectedReferences),
+		)
+	}
+
+	for i := range expectedReferences {
+		if expectedReferences[i] != actualSuggestions[i].reference {
+			t.Fatalf("%v != %v", expectedReferences[i], actualSuggestions[i].reference)
+		}
+	}
+
+	if actualSuggestions[0].score <= actualSuggestions[1].score {
+		t.Fatalf("Suggestions are not ordered by score: %v, %v", *actualSuggestions[0], *actualSuggestions[1])
+	}
+}
+
+func TestReferenceMatcher(t *testing.T) {
+	refs := newReferenceMatcher()
+	refs.add(matchRegex, "^J BLOGGS [0-9]+$", []string{"A4"})
+	refs.add(matchContains, "BLOGGS", []string{"A3"})
+	refs.add(matchPrefix, "J BLOGGS", []string{"A2"})
+	refs.add(matchLiteral, "J Bloggs 0001", []string{"A1"})
+
+	testCases := []struct {
+		reference string
+		expected  string
+	}{
+		{"J BLOGGS 0001", "literal:J BLOGGS 0001"},
+		{"J BLOGGS 0002", "prefix:J BLOGGS"},
+		{"MRS BLOGGS", "contains:BLOGGS"},
+		{"NO MATCH", ""},
+	}
+
+	for _, testCase := range testCases {
+		rule, ok := refs.match(testCase.reference, time.Time{})
+		if ok != (len(testCase.expected) > 0) || (ok && rule.String() != testCase.expected) {
+			t.Fatalf("%q matched %v, expected %q", testCase.reference, rule, testCase.expected)
+		}
+	}
+
+	if _, err := refs.add(matchLiteral, "j bloggs 0001", []string{"A5"}); err != errDuplicateReference {
+		t.Fatalf("Expected a duplicate reference error, got %v", err)
+	}
+
+	if _, err := refs.add(matchRegex, "([", []string{"A5"}); err == nil {
+		t.Fatalf("Expected an error for an invalid regular expression")
+	}
+
+	if _, err := refs.add("fuzzy", "J BLOGGS", []string{"A5"}); err == nil {
+		t.Fatalf("Expected an error for an unknown match kind")
+	}
+}
+
+func TestReferenceMatcherValidity(t *testing.T) {
+	day := func(s string) time.Time {
+		date, _ := time.Parse(ledgerDateFormat, s)
+		return date
+	}
+
+	refs := newReferenceMatcher()
+	if _, err := refs.addValid(matchLiteral, "JOE BLOGGS", []string{"A1"}, time.Time{}, day("2018-02-28")); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if _, err := refs.addValid(matchLiteral, "JOE BLOGGS", []string{"A2"}, day("2018-03-01"), time.Time{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if _, err := refs.addValid(matchLiteral, "JOE BLOGGS", []string{"A3"}, day("2018-02-01"), time.Time{}); err != errDuplicateReference {
+		t.Fatalf("Expected an overlapping mapping to be a duplicate, got %v", err)
+	}
+
+	if _, err := refs.addValid(matchPrefix, "SMITH", []string{"A4"}, day("2018-02-01"), day("2018-01-01")); err == nil {
+		t.Fatalf("Expected an error for a mapping that ends before it starts")
+	}
+
+	refs.addValid(matchPrefix, "SMITH", []string{"A4"}, day("2018-02-01"), day("2018-02-28"))
+
+	tests := []struct {
+		reference string
+		date      string
+		memberID  string
+	}{
+		{"JOE BLOGGS", "2018-01-15", "A1"},
+		{"JOE BLOGGS", "2018-02-28", "A1"},
+		{"JOE BLOGGS", "2018-03-01", "A2"},
+		{"SMITH J", "2018-01-31", ""},
+		{"SMITH J", "2018-02-14", "A4"},
+		{"SMITH J", "2018-03-01", ""},
+	}
+
+	for _, test := range tests {
+		rule, ok := refs.match(test.reference, day(test.date))
+		if len(test.memberID) == 0 {
+			if ok {
+				t.Fatalf("%v on %v unexpectedly matched %v", test.reference, test.date, rule)
+			}
+		} else if !ok || rule.memberIDs[0] != test.memberID {
+			t.Fatalf("%v on %v: %v != %v", test.reference, test.date, rule, test.memberID)
+		}
+	}
+}
+
+func TestSplitKnownReferences(t *testing.T) {
+	refs := newReferenceMatcher()
+	refs.add(matchLiteral, "JOE BLOGGS", []string{"A123456"})
+	txns := []*bankTxn{
+		{description: "FP JOE BLOGGS", amount: decimal.New(45, 0)},
+		{description: "RAFFLE", amount: decimal.New(5, 0)},
+	}
+
+	knownTxns, unknownTxns := splitKnownReferences(txns, refs)
+
+	if len(knownTxns) != 1 || knownTxns[0] != txns[0] {
+		t.Fatalf("Unexpected known txns: %v", knownTxns)
+	}
+
+	if len(unknownTxns) != 1 || unknownTxns[0] != txns[1] {
+		t.Fatalf("Unexpected unknown txns: %v", unknownTxns)
+	}
+}
+
+func TestIdentifyDuplicatePayments(t *testing.T) {
+	refs := newReferenceMatcher()
+	refs.add(matchLiteral, "JOE BLOGGS", []string{"A1"})
+	refs.add(matchLiteral, "J BLOGGS SUBS", []string{"A1"})
+	refs.add(matchLiteral, "SMITHS", []string{"A2", "A3"})
+	refs.add(matchLiteral, "ANN", []string{"A3"})
+
+	txns := []*bankTxn{
+		{description: "JOE BLOGGS", amount: decimal.New(185, -1)},
+		{description: "FP J BLOGGS SUBS", amount: decimal.New(185, -1)},
+		{description: "SMITHS", amount: decimal.New(30, 0)},
+		{description: "ANN", amount: decimal.New(185, -1)},
+		{description: "NOBODY", amount: decimal.New(185, -1)},
+	}
+
+	duplicates := identifyDuplicatePayments(txns, refs)
+	if len(duplicates) != 2 {
+		t.Fatalf("%v != %v", len(duplicates), 2)
+	}
+
+	if duplicates[0].memberID != "A1" || len(duplicates[0].txns) != 2 || duplicates[0].rules[1].pattern != "J BLOGGS SUBS" {
+		t.Fatalf("Wrong duplicate for A1: %v", duplicates[0])
+	}
+
+	if duplicates[1].memberID != "A3" || duplicates[1].txns[0] != txns[2] || duplicates[1].txns[1] != txns[3] {
+		t.Fatalf("Wrong duplicate for A3: %v", duplicates[1])
+	}
+}
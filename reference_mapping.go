@@ -0,0 +1,290 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+func parseMemberIDs(memberIDs string) (parsed []string) {
+	for _, memberID := range strings.Split(memberIDs, "|") {
+		memberID = strings.ToUpper(strings.TrimSpace(memberID))
+		if len(memberID) > 0 {
+			parsed = append(parsed, memberID)
+		}
+	}
+
+	return parsed
+}
+
+// Tidies a row of the reference mapping the same way it's read when loaded,
+// so the file holds what's actually matched.
+func normaliseMemberReference(row *MemberReference) (*MemberReference, error) {
+	kind := strings.ToLower(strings.TrimSpace(row.Match))
+	normalised := &MemberReference{
+		MemberIDs:   strings.Join(parseMemberIDs(row.MemberIDs), "|"),
+		FeeCategory: strings.ToLower(strings.TrimSpace(row.FeeCategory)),
+	}
+
+	switch kind {
+	case "", matchLiteral:
+		normalised.Reference = normaliseReference(row.Reference)
+	case matchPrefix, matchContains:
+		normalised.Match = kind
+		normalised.Reference = normaliseReference(row.Reference)
+	case matchRegex:
+		normalised.Match = kind
+		normalised.Reference = strings.TrimSpace(row.Reference)
+		if _, err := regexp.Compile(normalised.Reference); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("Unknown match kind %v (expected one of %v)", kind, matchKinds)
+	}
+
+	if len(normalised.Reference) == 0 {
+		return nil, fmt.Errorf("Reference %q is empty once normalised", row.Reference)
+	}
+
+	validFrom, validTo, err := parseValidity(row.ValidFrom, row.ValidTo)
+	if err != nil {
+		return nil, err
+	}
+
+	if !validFrom.IsZero() {
+		normalised.ValidFrom = validFrom.Format(ledgerDateFormat)
+	}
+
+	if !validTo.IsZero() {
+		normalised.ValidTo = validTo.Format(ledgerDateFormat)
+	}
+
+	if !validFrom.IsZero() && !validTo.IsZero() && validTo.Before(validFrom) {
+		return nil, fmt.Errorf("%v is valid to %v, before it's valid from %v", normalised, normalised.ValidTo, normalised.ValidFrom)
+	}
+
+	return normalised, nil
+}
+
+func (r *MemberReference) rule() *referenceRule {
+	validFrom, validTo, _ := parseValidity(r.ValidFrom, r.ValidTo)
+	return &referenceRule{kind: r.kind(), pattern: r.Reference, validFrom: validFrom, validTo: validTo}
+}
+
+func (r *MemberReference) kind() string {
+	if len(r.Match) == 0 {
+		return matchLiteral
+	}
+
+	return r.Match
+}
+
+func (r *MemberReference) String() string {
+	return r.kind() + ":" + r.Reference
+}
+
+type referenceMapping struct {
+	rows []*MemberReference
+}
+
+func newReferenceMapping(rows []*MemberReference) (*referenceMapping, error) {
+	rm := &referenceMapping{}
+	for _, row := range rows {
+		normalised, err := normaliseMemberReference(row)
+		if err != nil {
+			return nil, err
+		}
+
+		rm.rows = append(rm.rows, normalised)
+	}
+
+	return rm, nil
+}
+
+// A reference mapped for more than one period needs validFrom to pick the row.
+func (rm *referenceMapping) index(kind, reference, validFrom string) (int, error) {
+	key, err := normaliseMemberReference(&MemberReference{Reference: reference, Match: kind, ValidFrom: validFrom})
+	if err != nil {
+		return -1, err
+	}
+
+	found := []int{}
+	for i, row := range rm.rows {
+		if row.String() == key.String() && (len(key.ValidFrom) == 0 || row.ValidFrom == key.ValidFrom) {
+			found = append(found, i)
+		}
+	}
+
+	if len(found) == 0 {
+		return -1, fmt.Errorf("%v is not mapped", key)
+	} else if len(found) > 1 {
+		return -1, fmt.Errorf("%v is mapped for %v periods, so give the date it's valid from", key, len(found))
+	}
+
+	return found[0], nil
+}
+
+func checkMemberIDs(memberIDs []string, members map[string]*Member) error {
+	if len(memberIDs) == 0 {
+		return fmt.Errorf("No member IDs given")
+	}
+
+	unknown := []string{}
+	for _, memberID := range memberIDs {
+		if _, ok := members[memberID]; !ok {
+			unknown = append(unknown, memberID)
+		}
+	}
+
+	if len(unknown) > 0 {
+		return fmt.Errorf("Unknown member IDs %v", unknown)
+	}
+
+	return nil
+}
+
+func (rm *referenceMapping) add(row *MemberReference, members map[string]*Member) (*MemberReference, error) {
+	normalised, err := normaliseMemberReference(row)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range rm.rows {
+		if row.String() == normalised.String() && row.rule().overlaps(normalised.rule()) {
+			return nil, fmt.Errorf("%v is already mapped to %v for some of the same period", normalised, row.MemberIDs)
+		}
+	}
+
+	err = checkMemberIDs(parseMemberIDs(normalised.MemberIDs), members)
+	if err != nil {
+		return nil, err
+	}
+
+	rm.rows = append(rm.rows, normalised)
+
+	return normalised, nil
+}
+
+func (rm *referenceMapping) remove(kind, reference, validFrom string) (*MemberReference, error) {
+	i, err := rm.index(kind, reference, validFrom)
+	if err != nil {
+		return nil, err
+	}
+
+	removed := rm.rows[i]
+	rm.rows = append(rm.rows[:i], rm.rows[i+1:]...)
+
+	return removed, nil
+}
+
+/*
+Without from, the reference is re-pointed for the whole of the period it's
+valid for. With from, the existing mapping ends the day before and a new one
+starts on from, so earlier months still resolve to the old members.
+*/
+func (rm *referenceMapping) move(kind, reference, validFrom, memberIDs, from string, members map[string]*Member) (*MemberReference, error) {
+	i, err := rm.index(kind, reference, validFrom)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed := parseMemberIDs(memberIDs)
+	err = checkMemberIDs(parsed, members)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(from) == 0 {
+		rm.rows[i].MemberIDs = strings.Join(parsed, "|")
+		return rm.rows[i], nil
+	}
+
+	existing := rm.rows[i]
+	fromDate, _, err := parseValidity(from, "")
+	if err != nil {
+		return nil, err
+	}
+
+	rule := existing.rule()
+	if !rule.validFrom.IsZero() && !fromDate.After(rule.validFrom) {
+		return nil, fmt.Errorf("%v is only valid from %v, so it can't move on %v", existing, existing.ValidFrom, from)
+	}
+
+	if !rule.validTo.IsZero() && fromDate.After(rule.validTo) {
+		return nil, fmt.Errorf("%v is only valid to %v, so it can't move on %v", existing, existing.ValidTo, from)
+	}
+
+	moved := *existing
+	moved.MemberIDs = strings.Join(parsed, "|")
+	moved.ValidFrom = fromDate.Format(ledgerDateFormat)
+	existing.ValidTo = fromDate.AddDate(0, 0, -1).Format(ledgerDateFormat)
+	rm.rows = append(rm.rows, &moved)
+
+	return &moved, nil
+}
+
+// Rows whose reference contains or matches text, or that map memberID.
+func (rm *referenceMapping) find(text, memberID string) (found []*MemberReference) {
+	reference := normaliseReference(text)
+	memberID = strings.ToUpper(strings.TrimSpace(memberID))
+	for _, row := range rm.rows {
+		matched := false
+		if len(reference) > 0 {
+			rule, err := newReferenceMatcher().add(row.kind(), row.Reference, nil)
+			matched = strings.Contains(row.Reference, reference) || (err == nil && rule.matches(reference))
+		}
+
+		if len(memberID) > 0 {
+			for _, rowMemberID := range parseMemberIDs(row.MemberIDs) {
+				if rowMemberID == memberID {
+					matched = true
+				}
+			}
+		}
+
+		if matched {
+			found = append(found, row)
+		}
+	}
+
+	return found
+}
+
+/*
+Literal references are sorted alphabetically. The other rules are tried in
+file order, so they only move to after the literals, grouped by kind, and
+otherwise keep their order. Mappings of the same reference are kept together,
+where the reference first appears, in date order.
+*/
+func (rm *referenceMapping) sort() {
+	precedence := map[string]int{}
+	for i, kind := range matchKinds {
+		precedence[kind] = i
+	}
+
+	// Rules are grouped where their reference first appears in the file.
+	firstSeen := make(map[string]int)
+	for i, row := range rm.rows {
+		if _, ok := firstSeen[row.String()]; !ok {
+			firstSeen[row.String()] = i
+		}
+	}
+
+	sort.SliceStable(rm.rows, func(i, j int) bool {
+		left, right := rm.rows[i], rm.rows[j]
+		if left.kind() != right.kind() {
+			return precedence[left.kind()] < precedence[right.kind()]
+		}
+
+		if left.Reference != right.Reference {
+			if left.kind() == matchLiteral {
+				return left.Reference < right.Reference
+			}
+
+			return firstSeen[left.String()] < firstSeen[right.String()]
+		}
+
+		return left.ValidFrom < right.ValidFrom
+	})
+}
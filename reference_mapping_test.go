@@ -0,0 +1,130 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestReferenceMapping(t *testing.T) {
+	members := map[string]*Member{"A1": {MemberID: "A1"}, "A2": {MemberID: "A2"}, "A3": {MemberID: "A3"}}
+	mapping, err := newReferenceMapping([]*MemberReference{
+		{Reference: "zed ", MemberIDs: "a1 ", Match: ""},
+		{Reference: "^SMITH [0-9]+$", MemberIDs: "A2", Match: "Regex"},
+		{Reference: "fp alpha", MemberIDs: "A2| A3", Match: "literal"},
+		{Reference: "BLOGGS", MemberIDs: "A3", Match: "prefix"},
+		{Reference: "^JONES", MemberIDs: "A3", Match: "regex"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if row := mapping.rows[2]; row.Reference != "ALPHA" || row.MemberIDs != "A2|A3" || row.Match != "" {
+		t.Fatalf("Row not normalised: %v", row)
+	}
+
+	if _, err := mapping.add(&MemberReference{Reference: "Alpha", MemberIDs: "A1"}, members); err == nil {
+		t.Fatalf("Expected an error adding a duplicate reference")
+	}
+
+	if _, err := mapping.add(&MemberReference{Reference: "Beta", MemberIDs: "A9"}, members); err == nil {
+		t.Fatalf("Expected an error adding an unknown member ID")
+	}
+
+	if _, err := mapping.add(&MemberReference{Reference: "so Beta", MemberIDs: "a1|"}, members); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if _, err := mapping.move("", "BETA", "", "A2", "", members); err != nil || mapping.rows[5].MemberIDs != "A2" {
+		t.Fatalf("Move failed: %v %v", err, mapping.rows[5])
+	}
+
+	if _, err := mapping.remove("", "zed", ""); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if _, err := mapping.remove("", "zed", ""); err == nil {
+		t.Fatalf("Expected an error removing a missing reference")
+	}
+
+	if found := mapping.find("BLOGGS J", ""); len(found) != 1 || found[0].Reference != "BLOGGS" {
+		t.Fatalf("Wrong references found: %v", found)
+	}
+
+	if found := mapping.find("", "a3"); len(found) != 3 {
+		t.Fatalf("Wrong references found for A3: %v", found)
+	}
+
+	mapping.sort()
+	expected := []string{"literal:ALPHA", "literal:BETA", "prefix:BLOGGS", "regex:^SMITH [0-9]+$", "regex:^JONES"}
+	for i, row := range mapping.rows {
+		if row.String() != expected[i] {
+			t.Fatalf("%v != %v", row, expected[i])
+		}
+	}
+}
+
+func TestReferenceMappingValidity(t *testing.T) {
+	members := map[string]*Member{"A1": {MemberID: "A1"}, "A2": {MemberID: "A2"}}
+	mapping, err := newReferenceMapping([]*MemberReference{
+		{Reference: "ALPHA", MemberIDs: "A1", ValidFrom: "2018-01-01"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if _, err := mapping.move("", "ALPHA", "", "A2", "2018-01-01", members); err == nil {
+		t.Fatalf("Expected an error moving on the day the mapping starts")
+	}
+
+	if _, err := mapping.move("", "ALPHA", "", "A2", "2018-03-01", members); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if row := mapping.rows[0]; row.MemberIDs != "A1" || row.ValidTo != "2018-02-28" {
+		t.Fatalf("Old mapping not ended: %v %v %v", row, row.MemberIDs, row.ValidTo)
+	}
+
+	if row := mapping.rows[1]; row.MemberIDs != "A2" || row.ValidFrom != "2018-03-01" || len(row.ValidTo) > 0 {
+		t.Fatalf("New mapping wrong: %v %v %v %v", row, row.MemberIDs, row.ValidFrom, row.ValidTo)
+	}
+
+	if _, err := mapping.add(&MemberReference{Reference: "ALPHA", MemberIDs: "A1", ValidTo: "2017-12-31"}, members); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if _, err := mapping.add(&MemberReference{Reference: "ALPHA", MemberIDs: "A1", ValidFrom: "2018-02-01", ValidTo: "2018-03-31"}, members); err == nil {
+		t.Fatalf("Expected an error adding an overlapping mapping")
+	}
+
+	if _, err := mapping.remove("", "ALPHA", ""); err == nil {
+		t.Fatalf("Expected an error removing a reference mapped for several periods")
+	}
+
+	if _, err := mapping.remove("", "ALPHA", "2018-03-01"); err != nil || len(mapping.rows) != 2 {
+		t.Fatalf("Remove failed: %v %v", err, len(mapping.rows))
+	}
+
+	mapping.sort()
+	if mapping.rows[0].ValidTo != "2017-12-31" || mapping.rows[1].ValidFrom != "2018-01-01" {
+		t.Fatalf("Not sorted by date: %v %v", mapping.rows[0].ValidTo, mapping.rows[1].ValidFrom)
+	}
+}
+
+func TestReferenceMappingSortRules(t *testing.T) {
+	mapping, err := newReferenceMapping([]*MemberReference{
+		{Reference: "SMITH", MemberIDs: "A1", Match: "prefix", ValidFrom: "2018-03-01"},
+		{Reference: "JONES", MemberIDs: "A2", Match: "prefix"},
+		{Reference: "SMITH", MemberIDs: "A3", Match: "prefix", ValidTo: "2018-02-28"},
+		{Reference: "BLOGGS", MemberIDs: "A4", Match: "prefix"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	mapping.sort()
+	expected := []string{"A3", "A1", "A2", "A4"}
+	for i, row := range mapping.rows {
+		if row.MemberIDs != expected[i] {
+			t.Fatalf("%v != %v", row.MemberIDs, expected[i])
+		}
+	}
+}
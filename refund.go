@@ -0,0 +1,213 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type memberRefund struct {
+	memberID  string
+	paid      decimal.Decimal
+	refunded  decimal.Decimal
+	refunds   []*bankTxn
+	cancelled bool
+}
+
+func (r *memberRefund) net() decimal.Decimal {
+	return r.paid.Sub(r.refunded)
+}
+
+// Whether every payment the member made in the period has been paid back.
+func (r *memberRefund) fullyRefunded() bool {
+	return r.cancelled
+}
+
+// A payment or refund shared between several members is split evenly.
+func (e *ledgerEntry) memberShare() decimal.Decimal {
+	if len(e.memberIDs) < 2 {
+		return e.txn.amount
+	}
+
+	return e.txn.amount.Div(decimal.New(int64(len(e.memberIDs)), 0)).Round(2)
+}
+
+/*
+Nets each member's refunds in the period from-until against their membership
+payments in the same period. Only members with a refund in the period are
+returned. Whether they're fully refunded comes from pairing refunds with the
+payments they pay back, so refunding a duplicate of an earlier month doesn't
+cancel the period's own payment.
+*/
+func identifyRefunds(entries []*ledgerEntry, from, until time.Time) (refunds []*memberRefund) {
+	byMember := make(map[string]*memberRefund)
+	memberRefundFor := func(memberID string) *memberRefund {
+		refund, ok := byMember[memberID]
+		if !ok {
+			refund = &memberRefund{memberID: memberID}
+			byMember[memberID] = refund
+		}
+
+		return refund
+	}
+
+	for _, entry := range entries {
+		if entry.txn.date.Before(from) || !entry.txn.date.Before(until) {
+			continue
+		}
+
+		if entry.classification == txnRefund {
+			for _, memberID := range entry.memberIDs {
+				refund := memberRefundFor(memberID)
+				refund.refunded = refund.refunded.Add(entry.memberShare().Abs())
+				refund.refunds = append(refund.refunds, entry.txn)
+			}
+		}
+	}
+
+	refundedPayments := identifyRefundedPayments(entries)
+	paidIn, keptIn := make(map[string]int), make(map[string]int)
+	for _, entry := range entries {
+		if entry.txn.date.Before(from) || !entry.txn.date.Before(until) || !entry.isMembershipPayment() {
+			continue
+		}
+
+		for _, memberID := range entry.memberIDs {
+			if refund, ok := byMember[memberID]; ok {
+				refund.paid = refund.paid.Add(entry.memberShare())
+				paidIn[memberID]++
+				if !refundedPayments[memberPayment{entry, memberID}] {
+					keptIn[memberID]++
+				}
+			}
+		}
+	}
+
+	for memberID, refund := range byMember {
+		refund.cancelled = paidIn[memberID] > 0 && keptIn[memberID] == 0
+		refunds = append(refunds, refund)
+	}
+
+	sort.Slice(refunds, func(i, j int) bool {
+		return refunds[i].memberID < refunds[j].memberID
+	})
+
+	return refunds
+}
+
+type memberPayment struct {
+	entry    *ledgerEntry
+	memberID string
+}
+
+/*
+Pairs each refund with the membership payments it pays back. Each member's
+share of a refund is taken off a payment made on or before it: first one in a
+month the member paid more than once, as refunds usually pay back duplicates,
+and otherwise their latest payment, then the one before that, and so on.
+Returns the member payments that have been refunded in full, which no longer
+count as cover. A part refund leaves the payment's cover alone.
+*/
+func identifyRefundedPayments(entries []*ledgerEntry) (refunded map[memberPayment]bool) {
+	refunds := []*ledgerEntry{}
+	payments := make(map[string][]*ledgerEntry)
+	for _, entry := range entries {
+		if entry.classification == txnRefund {
+			refunds = append(refunds, entry)
+		} else if entry.isMembershipPayment() {
+			for _, memberID := range entry.memberIDs {
+				payments[memberID] = append(payments[memberID], entry)
+			}
+		}
+	}
+
+	sort.SliceStable(refunds, func(i, j int) bool {
+		return refunds[i].txn.date.Before(refunds[j].txn.date)
+	})
+
+	remaining := make(map[memberPayment]decimal.Decimal)
+	refunded = make(map[memberPayment]bool)
+	for _, refund := range refunds {
+		for _, memberID := range refund.memberIDs {
+			memberPayments := payments[memberID]
+			sort.SliceStable(memberPayments, func(i, j int) bool {
+				return memberPayments[i].txn.date.After(memberPayments[j].txn.date)
+			})
+
+			amount := refund.memberShare().Abs()
+			for amount.IsPositive() {
+				payment, ok := nextRefundedPayment(memberPayments, memberID, refund.txn.date, refunded)
+				if !ok {
+					break
+				}
+
+				left, ok := remaining[payment]
+				if !ok {
+					left = payment.entry.memberShare()
+				}
+
+				offset := left
+				if amount.LessThan(left) {
+					offset = amount
+				}
+
+				amount = amount.Sub(offset)
+				remaining[payment] = left.Sub(offset)
+				if !remaining[payment].IsPositive() {
+					refunded[payment] = true
+				}
+			}
+		}
+	}
+
+	return refunded
+}
+
+// payments are latest first. A payment in a month the member paid for more
+// than once is picked before any other.
+func nextRefundedPayment(payments []*ledgerEntry, memberID string, date time.Time, refunded map[memberPayment]bool) (memberPayment, bool) {
+	candidates := []memberPayment{}
+	paidIn := make(map[time.Time]int)
+	for _, entry := range payments {
+		payment := memberPayment{entry, memberID}
+		if entry.txn.date.After(date) || refunded[payment] {
+			continue
+		}
+
+		month, _ := monthPeriod(entry.txn.date)
+		paidIn[month]++
+		candidates = append(candidates, payment)
+	}
+
+	if len(candidates) == 0 {
+		return memberPayment{}, false
+	}
+
+	for _, payment := range candidates {
+		month, _ := monthPeriod(payment.entry.txn.date)
+		if paidIn[month] > 1 {
+			return payment, true
+		}
+	}
+
+	return candidates[0], true
+}
+
+func removeRefundedMemberIds(memberIds []string, refunds []*memberRefund) (remaining []string) {
+	refunded := make(map[string]bool)
+	for _, refund := range refunds {
+		if refund.fullyRefunded() {
+			refunded[refund.memberID] = true
+		}
+	}
+
+	remaining = []string{}
+	for _, memberID := range memberIds {
+		if !refunded[memberID] {
+			remaining = append(remaining, memberID)
+		}
+	}
+
+	return remaining
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestIdentifyRefunds(t *testing.T) {
+	refund := func(day int, amount decimal.Decimal, memberIDs ...string) *ledgerEntry {
+		entry := newTestLedgerEntry(2018, time.February, day, "REFUND", amount, memberIDs...)
+		entry.classification = txnRefund
+		return entry
+	}
+
+	entries := []*ledgerEntry{
+		newTestLedgerEntry(2018, time.February, 1, "FULL", decimal.New(185, -1), "A1"),
+		refund(10, decimal.New(-185, -1), "A1"),
+		newTestLedgerEntry(2018, time.February, 1, "PART", decimal.New(30, 0), "A2"),
+		refund(10, decimal.New(-115, -1), "A2"),
+		newTestLedgerEntry(2018, time.February, 1, "KEPT", decimal.New(185, -1), "A3"),
+		newTestLedgerEntry(2018, time.January, 1, "EARLIER", decimal.New(185, -1), "A4"),
+		refund(10, decimal.New(-10, 0), "A4"),
+		newTestLedgerEntry(2018, time.March, 1, "LATER", decimal.New(185, -1), "A1"),
+	}
+
+	from, until := monthPeriod(time.Date(2018, 2, 1, 0, 0, 0, 0, time.UTC))
+	refunds := identifyRefunds(entries, from, until)
+	if len(refunds) != 3 {
+		t.Fatalf("%v != %v", len(refunds), 3)
+	}
+
+	testCases := []struct {
+		memberID      string
+		net           decimal.Decimal
+		fullyRefunded bool
+	}{
+		{"A1", decimal.Zero, true},
+		{"A2", decimal.New(185, -1), false},
+		{"A4", decimal.New(-10, 0), false},
+	}
+
+	for i, testCase := range testCases {
+		if refunds[i].memberID != testCase.memberID || !refunds[i].net().Equal(testCase.net) || refunds[i].fullyRefunded() != testCase.fullyRefunded {
+			t.Fatalf("%v != %v", refunds[i], testCase)
+		}
+	}
+
+	remaining := removeRefundedMemberIds([]string{"A1", "A2", "A3"}, refunds)
+	if !reflect.DeepEqual(remaining, []string{"A2", "A3"}) {
+		t.Fatalf("%v != %v", remaining, []string{"A2", "A3"})
+	}
+}
+
+func TestRefundCancelsCoverage(t *testing.T) {
+	schedules := []*feeSchedule{
+		{
+			effectiveFrom:  time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC),
+			correctAmounts: []decimal.Decimal{decimal.New(185, -1)},
+		},
+	}
+	refund := newTestLedgerEntry(2018, time.February, 12, "REFUND", decimal.New(-185, -1), "A1")
+	refund.classification = txnRefund
+	partRefund := newTestLedgerEntry(2018, time.February, 12, "REFUND", decimal.New(-5, 0), "A2")
+	partRefund.classification = txnRefund
+	entries := []*ledgerEntry{
+		newTestLedgerEntry(2018, time.February, 10, "REFUNDED", decimal.New(185, -1), "A1"),
+		refund,
+		newTestLedgerEntry(2018, time.February, 10, "PART", decimal.New(185, -1), "A2"),
+		partRefund,
+	}
+
+	coverage := identifyCoverage(entries, schedules)
+	if len(coverage["A1"]) != 0 || len(coverage["A2"]) != 1 {
+		t.Fatalf("Wrong coverage: %v", coverage)
+	}
+
+	from, until := monthPeriod(time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC))
+	statuses := identifyMemberStatuses(coverage, from, until, 30*24*time.Hour)
+	if len(statuses) != 1 || statuses[0].memberID != "A2" {
+		t.Fatalf("Refunded member still has a status in the following month: %v", statuses)
+	}
+}
+
+func TestRefundOfDuplicatePayment(t *testing.T) {
+	schedules := []*feeSchedule{
+		{
+			effectiveFrom:  time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC),
+			correctAmounts: []decimal.Decimal{decimal.New(185, -1)},
+		},
+	}
+	refund := newTestLedgerEntry(2018, time.February, 20, "REFUND", decimal.New(-185, -1), "A1")
+	refund.classification = txnRefund
+	duplicate := newTestLedgerEntry(2018, time.January, 20, "DUPLICATE", decimal.New(185, -1), "A1")
+	entries := []*ledgerEntry{
+		newTestLedgerEntry(2018, time.January, 3, "JANUARY", decimal.New(185, -1), "A1"),
+		duplicate,
+		newTestLedgerEntry(2018, time.February, 3, "FEBRUARY", decimal.New(185, -1), "A1"),
+		refund,
+	}
+
+	refunded := identifyRefundedPayments(entries)
+	if len(refunded) != 1 || !refunded[memberPayment{duplicate, "A1"}] {
+		t.Fatalf("Refund not paired with the duplicate: %v", refunded)
+	}
+
+	from, until := monthPeriod(time.Date(2018, 2, 1, 0, 0, 0, 0, time.UTC))
+	refunds := identifyRefunds(entries, from, until)
+	if len(refunds) != 1 || refunds[0].fullyRefunded() {
+		t.Fatalf("February's payment counted as refunded: %v", refunds)
+	}
+
+	if remaining := removeRefundedMemberIds([]string{"A1"}, refunds); !reflect.DeepEqual(remaining, []string{"A1"}) {
+		t.Fatalf("%v != %v", remaining, []string{"A1"})
+	}
+
+	statuses := identifyMemberStatuses(identifyCoverage(entries, schedules), from, until, 30*24*time.Hour)
+	if len(statuses) != 1 || statuses[0].status != statusActive {
+		t.Fatalf("%v != %v", statuses[0].status, statusActive)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type balanceBreak struct {
+	previous *bankTxn
+	txn      *bankTxn
+	expected decimal.Decimal
+}
+
+func (b *balanceBreak) String() string {
+	return fmt.Sprintf("%v %v %v should have left a balance of %v (after %v %v at %v) but the statement says %v",
+		b.txn.date.Format(ledgerDateFormat), b.txn.description, b.txn.amount,
+		b.expected, b.previous.date.Format(ledgerDateFormat), b.previous.description, *b.previous.balance, *b.txn.balance)
+}
+
+// Replays the amounts oldest first against the running balance. Rows without
+// a balance are carried into the next row that has one.
+func replayBalances(txns []*bankTxn) (breaks []*balanceBreak) {
+	var previous *bankTxn
+	var running decimal.Decimal
+	for _, txn := range txns {
+		if previous == nil {
+			if txn.balance != nil {
+				previous = txn
+				running = *txn.balance
+			}
+
+			continue
+		}
+
+		running = running.Add(txn.amount)
+		if txn.balance != nil {
+			if !running.Equal(*txn.balance) {
+				breaks = append(breaks, &balanceBreak{previous, txn, running})
+			}
+
+			previous = txn
+			running = *txn.balance
+		}
+	}
+
+	return breaks
+}
+
+/*
+A break in the statement's running balance means rows are missing, the export
+was truncated or files were joined badly. Statements list transactions oldest
+or newest first, so both orders are replayed and the one that fits best is
+reported.
+*/
+func checkBalanceContinuity(txns []*bankTxn) []*balanceBreak {
+	reversed := make([]*bankTxn, len(txns))
+	for i, txn := range txns {
+		reversed[len(txns)-1-i] = txn
+	}
+
+	oldestFirst := replayBalances(txns)
+	newestFirst := replayBalances(reversed)
+	if len(newestFirst) < len(oldestFirst) {
+		return newestFirst
+	}
+
+	return oldestFirst
+}
+
+func splitByPeriod(txns []*bankTxn, from, until time.Time) (inPeriod []*bankTxn, outOfPeriod []*bankTxn) {
+	for _, txn := range txns {
+		if txn.date.Before(from) || !txn.date.Before(until) {
+			outOfPeriod = append(outOfPeriod, txn)
+		} else {
+			inPeriod = append(inPeriod, txn)
+		}
+	}
+
+	return inPeriod, outOfPeriod
+}
+
+// A statement with nothing in the period is almost certainly the wrong month's
+// export, so that's an error rather than a warning.
+func checkStatementPeriod(txns []*bankTxn, from, until time.Time) (inPeriod []*bankTxn, outOfPeriod []*bankTxn, err error) {
+	inPeriod, outOfPeriod = splitByPeriod(txns, from, until)
+	if len(txns) > 0 && len(inPeriod) == 0 {
+		first, last := txns[0].date, txns[0].date
+		for _, txn := range txns {
+			if txn.date.Before(first) {
+				first = txn.date
+			}
+
+			if txn.date.After(last) {
+				last = txn.date
+			}
+		}
+
+		return nil, nil, fmt.Errorf("The statement runs from %v to %v, which doesn't overlap %v to %v. Is it the right month's export?",
+			first.Format(ledgerDateFormat), last.Format(ledgerDateFormat), from.Format(ledgerDateFormat), until.AddDate(0, 0, -1).Format(ledgerDateFormat))
+	}
+
+	return inPeriod, outOfPeriod, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestCheckBalanceContinuity(t *testing.T) {
+	newTxn := func(description string, amount, balance int64) *bankTxn {
+		b := decimal.New(balance, 0)
+		return &bankTxn{description: description, amount: decimal.New(amount, 0), balance: &b}
+	}
+
+	continuous := []*bankTxn{
+		newTxn("A", 10, 1010),
+		newTxn("B", -5, 1005),
+		{description: "NO BALANCE", amount: decimal.New(20, 0)},
+		newTxn("C", 15, 1040),
+	}
+
+	if breaks := checkBalanceContinuity(continuous); len(breaks) != 0 {
+		t.Fatalf("Unexpected breaks: %v", breaks)
+	}
+
+	newestFirst := []*bankTxn{continuous[3], continuous[2], continuous[1], continuous[0]}
+	if breaks := checkBalanceContinuity(newestFirst); len(breaks) != 0 {
+		t.Fatalf("Unexpected breaks newest first: %v", breaks)
+	}
+
+	missingRow := []*bankTxn{
+		newTxn("A", 10, 1010),
+		newTxn("B", -5, 1005),
+		newTxn("D", 18, 1041),
+		newTxn("E", 2, 1043),
+	}
+
+	breaks := checkBalanceContinuity(missingRow)
+	if len(breaks) != 1 || breaks[0].txn != missingRow[2] || !breaks[0].expected.Equal(decimal.New(1023, 0)) {
+		t.Fatalf("Expected one break at D: %v", breaks)
+	}
+}
+
+func TestCheckStatementPeriod(t *testing.T) {
+	from, until := monthPeriod(time.Date(2018, 2, 1, 0, 0, 0, 0, time.UTC))
+	newTxn := func(month time.Month, day int) *bankTxn {
+		return &bankTxn{date: time.Date(2018, month, day, 0, 0, 0, 0, time.UTC)}
+	}
+
+	txns := []*bankTxn{newTxn(time.January, 30), newTxn(time.February, 1), newTxn(time.February, 28), newTxn(time.March, 1)}
+	inPeriod, outOfPeriod, err := checkStatementPeriod(txns, from, until)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(inPeriod) != 2 || inPeriod[0] != txns[1] || inPeriod[1] != txns[2] {
+		t.Fatalf("%v != %v", inPeriod, txns[1:3])
+	}
+
+	if len(outOfPeriod) != 2 || outOfPeriod[0] != txns[0] || outOfPeriod[1] != txns[3] {
+		t.Fatalf("%v != %v", outOfPeriod, []*bankTxn{txns[0], txns[3]})
+	}
+
+	_, _, err = checkStatementPeriod([]*bankTxn{newTxn(time.March, 1), newTxn(time.March, 31)}, from, until)
+	if err == nil {
+		t.Fatalf("Expected an error for a statement outside the period")
+	}
+}